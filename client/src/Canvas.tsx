@@ -1,4 +1,4 @@
-import { Room, getPlayerColor } from './Elements'
+import { Room, getPlayerColor, getStoneColor } from './Elements'
 import * as paper from "paper";
 import { Path, Point, PointText } from "paper";
 import React, { createRef,RefObject } from 'react';
@@ -15,7 +15,8 @@ function Playerlist(props: any) {
       </div>
         <span>Players: </span>
         {player_names.map((_, idx, arr) => {
-          return <span style={{color: getPlayerColor(idx).toCSS(true)}}>{player_names[idx]} </span>
+          const team = props.room.board.teams ? ` (team ${props.room.board.teams[idx] + 1})` : ""
+          return <span style={{color: getPlayerColor(idx).toCSS(true)}}>{player_names[idx]}{team} </span>
         })}
       <div>
         <span>Current player: </span>
@@ -27,6 +28,14 @@ function Playerlist(props: any) {
 
 let stone_anims = new Map<number, StoneAnimInfo>()
 
+const HOLE_SCALE = 75
+const HOLE_RADIUS = 35
+
+// Boards of more than two players are a ring of holes and need more room.
+const canvasHeight = (room: Room | undefined) => {
+  return room && room.board.num_players > 2 ? 640 : 400
+}
+
 interface CanvasProps {
   room: Room
   player: string
@@ -68,11 +77,30 @@ class Canvas extends React.Component<CanvasProps, CanvasState> {
     this.canvasRef = createRef<HTMLCanvasElement>()
     this.state = {
       width: 800,
-      height:400,
+      height: canvasHeight(props.room),
       loaded: false,
     }
   }
 
+  // layout fits the board's holes on the canvas, shrinking them when they are
+  // too close together to be drawn at full size.
+  layout = () => {
+    const holes = this.props.room.board.holes
+    let maxX = 0
+    let maxY = 0
+    let gap = Infinity
+    for (let [idx, hole] of holes.entries()) {
+      const next = holes[(idx + 1) % holes.length]
+      maxX = Math.max(maxX, Math.abs(hole.x))
+      maxY = Math.max(maxY, Math.abs(hole.y))
+      gap = Math.min(gap, Math.hypot(next.x - hole.x, next.y - hole.y))
+    }
+    const margin = HOLE_RADIUS + 25
+    const scale = Math.min(HOLE_SCALE, (this.state.width/2 - margin)/maxX, (this.state.height/2 - margin)/maxY)
+    const radius = Math.min(HOLE_RADIUS, 0.47*gap*scale)
+    return {scale: scale, radius: radius, stone: Math.max(4, radius*0.28), spread: radius*1.15}
+  }
+
   setupBoard = () => {
     this.layer = new paper.Layer()
     this.layer.activate()
@@ -97,8 +125,9 @@ class Canvas extends React.Component<CanvasProps, CanvasState> {
       return
     }
     this.layer?.removeChildren()
+    const layout = this.layout()
 
-    var rectangle = new paper.Rectangle(new Point(0, 0), new paper.Size(800, 400));
+    var rectangle = new paper.Rectangle(new Point(0, 0), new paper.Size(this.state.width, this.state.height));
     var cornerSize = new paper.Size(10, 10);
     var shape = new paper.Shape.Rectangle(rectangle, cornerSize);
     shape.strokeColor = new paper.Color('black');
@@ -110,8 +139,8 @@ class Canvas extends React.Component<CanvasProps, CanvasState> {
     }
 
     for (let [idx, hole] of this.props.room.board.holes.entries()) {
-        const cCenter = new Point(this.state.width/2 + hole.x*75, this.state.height/2 + hole.y*75)
-        let pcircle = new Path.Circle(cCenter, 35)
+        const cCenter = new Point(this.state.width/2 + hole.x*layout.scale, this.state.height/2 + hole.y*layout.scale)
+        let pcircle = new Path.Circle(cCenter, layout.radius)
         pcircle.strokeColor = getPlayerColor(hole.player)
         pcircle.fillColor = new paper.Color('#333333')
         let enter = ()=>{
@@ -145,7 +174,7 @@ class Canvas extends React.Component<CanvasProps, CanvasState> {
         pcircle.onMouseUp = up
         pcircle.sendToBack()
 
-        let tCenter = new Point(cCenter.x, cCenter.y + 47)
+        let tCenter = new Point(cCenter.x, cCenter.y + layout.radius + 12)
         let ptext = new PointText(tCenter)
         ptext.fillColor = new paper.Color('black')
         ptext.justification = 'center'
@@ -154,28 +183,28 @@ class Canvas extends React.Component<CanvasProps, CanvasState> {
         for (let [, stone] of hole.stones.entries()) {
           let frame = stone_anims.get(stone)
           if (frame && frame.hole !== idx) {
-            let scircle = new Path.Circle(new Point(frame.current_x, frame.current_y), 10)
-            scircle.fillColor = getPlayerColor(stone)
+            let scircle = new Path.Circle(new Point(frame.current_x, frame.current_y), layout.stone)
+            scircle.fillColor = getStoneColor(this.props.room, stone)
             frame.path = scircle
             frame.hole = idx
-            frame.target_x = this.state.width/2 + 75*hole.x + (Math.random()-0.5)*40
-            frame.target_y = this.state.height/2 + 75*hole.y + (Math.random()-0.5)*40
+            frame.target_x = cCenter.x + (Math.random()-0.5)*layout.spread
+            frame.target_y = cCenter.y + (Math.random()-0.5)*layout.spread
             frame.path.onMouseEnter = enter
             frame.path.onMouseLeave = leave
             frame.path.onMouseDown = down
             frame.path.onMouseUp = up
           } else if (frame) {
-            let scircle = new Path.Circle(new Point(frame.current_x, frame.current_y), 10)
-            scircle.fillColor = getPlayerColor(stone)
+            let scircle = new Path.Circle(new Point(frame.current_x, frame.current_y), layout.stone)
+            scircle.fillColor = getStoneColor(this.props.room, stone)
             frame.path = scircle
             frame.path.onMouseEnter = enter
             frame.path.onMouseLeave = leave
             frame.path.onMouseDown = down
             frame.path.onMouseUp = up
           } else if (!frame) {
-            const sCenter = new Point(cCenter.x + 40*(Math.random()-0.5), cCenter.y + 40*(Math.random()-0.5))
-            let scircle = new Path.Circle(sCenter, 10)
-            scircle.fillColor = getPlayerColor(stone)
+            const sCenter = new Point(cCenter.x + layout.spread*(Math.random()-0.5), cCenter.y + layout.spread*(Math.random()-0.5))
+            let scircle = new Path.Circle(sCenter, layout.stone)
+            scircle.fillColor = getStoneColor(this.props.room, stone)
             scircle.onMouseEnter = enter
             scircle.onMouseLeave = leave
             scircle.onMouseDown = down
@@ -201,7 +230,7 @@ class Canvas extends React.Component<CanvasProps, CanvasState> {
     this.setState((prevState) => {
       return {
         width: 800,
-        height: 400,
+        height: prevState.height,
         loaded: true
       }
     })
@@ -214,6 +243,14 @@ class Canvas extends React.Component<CanvasProps, CanvasState> {
     this.onImageLoad()
   }
 
+  componentDidUpdate() {
+    const height = canvasHeight(this.props.room)
+    if (this.state.loaded && height !== this.state.height) {
+      paper.view.viewSize = new paper.Size(this.state.width, height)
+      this.setState({height: height})
+    }
+  }
+
   render = () => {
     if (this.state.loaded) {
       this.drawBoard()
@@ -32,6 +32,7 @@ class GameBoard {
   current_player: number;
   rounds_repeated: number;
   finished: boolean;
+  teams: number[] | null;
 
   constructor(props: any) {
     this.holes = []
@@ -42,6 +43,7 @@ class GameBoard {
     this.current_player = props.current_player
     this.rounds_repeated = props.rounds_repeated
     this.finished = props.finished
+    this.teams = props.teams || null
   }
 }
 
@@ -93,6 +95,7 @@ class Rule {
     min: number;
     max: number;
     cycle_value_on_die: boolean;
+    drink: boolean;
 
     constructor(props: any) {
         this.event = new Event(props.event)
@@ -104,23 +107,58 @@ class Rule {
         this.min = props.min
         this.max = props.max
         this.cycle_value_on_die = props.cycle_value_on_die
+        this.drink = props.drink
     }
 }
 
+class StoneType {
+  stone: number;
+  colour: string;
+  label: string;
+  effect: string;
+
+  constructor(props: any) {
+    this.stone = props.stone
+    this.colour = props.colour
+    this.label = props.label
+    this.effect = props.effect
+  }
+}
+
+class TimelineEntry {
+  kind: string;
+  name: string;
+  text: string;
+
+  constructor(props: any) {
+    this.kind = props.kind
+    this.name = props.name
+    this.text = props.text
+  }
+}
+
 class Room {
   code: string;
   board: GameBoard;
   players: Player[];
   history: string[];
+  timeline: TimelineEntry[];
   rules: Rule[];
   sp_mode: boolean;
+  teams_mode: boolean;
+  stones: StoneType[];
 
   constructor(props: any) {
     this.code = props.code
     this.board = new GameBoard(props.board)
     this.players = []
     this.history = props.history
+    this.timeline = []
+    for (let jsonentry of props.timeline || []) {
+      this.timeline.push(new TimelineEntry(jsonentry))
+    }
     this.sp_mode = props.sp_mode
+    this.teams_mode = props.teams_mode
     for (let jsonplayer of props.players) {
       this.players.push(new Player(jsonplayer))
     }
@@ -128,6 +166,10 @@ class Room {
     for (let jsonrule of props.rules) {
         this.rules.push(new Rule(jsonrule))
       }
+    this.stones = []
+    for (let jsonstone of props.stones || []) {
+      this.stones.push(new StoneType(jsonstone))
+    }
   }
 }
 
@@ -137,6 +179,15 @@ const getPlayerColor = (idx: number) => {
   return color
 }
 
+const getStoneColor = (room: Room, stone: number) => {
+  for (let st of room.stones) {
+    if (st.stone === stone && st.colour) {
+      return new paper.Color(st.colour)
+    }
+  }
+  return getPlayerColor(stone)
+}
+
 const getPlayerNames = (room: Room) => {
   let player_names = new Array<string>(room.board.num_players)
   for (let i = 0; i < room.board.num_players; i++) {
@@ -148,4 +199,4 @@ const getPlayerNames = (room: Room) => {
   return player_names
 }
 
-export { Room, Player, GameBoard, Hole, Action, getPlayerColor, getPlayerNames, Rule, Event }
\ No newline at end of file
+export { Room, Player, GameBoard, Hole, Action, getPlayerColor, getPlayerNames, getStoneColor, Rule, Event, StoneType, TimelineEntry }
\ No newline at end of file
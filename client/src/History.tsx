@@ -1,29 +1,67 @@
 import React from 'react';
 import { Room, getPlayerColor } from './Elements'
 
+const REACTIONS = ["👍", "😂", "🍻", "🥂", "😱", "🔥", "👏", "🤮"]
+
 interface HistoryProps {
   room?: Room
+  send?: (msg: any) => void
 }
 
 interface HistoryState {
+  chat: string
 }
 
 class History extends React.Component<HistoryProps,HistoryState> {
+  constructor(props: HistoryProps) {
+    super(props)
+    this.state = {
+      chat: ""
+    }
+  }
+
+  onChatChange = (event: any) => {
+    this.setState({chat: event.target.value})
+  }
+
+  sendChat = (event: any) => {
+    event.preventDefault()
+    if (!this.state.chat || !this.props.send) {
+      return
+    }
+    this.props.send({"chat": this.state.chat})
+    this.setState({chat: ""})
+  }
+
   render() {
-    let mod = this.props.room?.history.length || 0
+    let timeline = this.props.room?.timeline || []
+    let mod = timeline.length
     return (
       <div className="scrollable card buttonlist">
         History:
-        {this.props.room?.history.map((_, index, array) => (
-          <div key={array.length - 1 - index} style={{ color: getPlayerColor(index+400-mod).toCSS(true) }}>{
-            array[array.length - 1 - index].split('\n').map((__, stridx, str) => (
+        <form onSubmit={this.sendChat}>
+          <input value={this.state.chat} onChange={this.onChatChange} placeholder="say something" maxLength={280}></input>
+        </form>
+        <div>
+          {REACTIONS.map((reaction) => (
+            <span key={reaction} className="cardanim" onClick={() => this.props.send?.({"reaction": reaction})}>{reaction}</span>
+          ))}
+        </div>
+        {timeline.map((_, index, array) => {
+          let entry = array[array.length - 1 - index]
+          if (entry.kind !== "history") {
+            let text = entry.kind === "reaction" ? `${entry.name} reacted ${entry.text}` : `${entry.name}: ${entry.text}`
+            return <div key={array.length - 1 - index}><i>{text}</i></div>
+          }
+          return <div key={array.length - 1 - index} style={{ color: getPlayerColor(index+400-mod).toCSS(true) }}>{
+            entry.text.split('\n').map((__, stridx, str) => (
               <div key={stridx}>{str[stridx]}</div>
             ))
           }</div>
-        ))}
+        })}
       </div>
     )
   }
 }
 
-export default History
\ No newline at end of file
+export default History
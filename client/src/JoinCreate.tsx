@@ -11,6 +11,8 @@ interface JoinCreateState {
   name: string
   join: string
   do_join: boolean
+  size: number
+  teams: boolean
 }
   
 class JoinCreate extends React.Component<JoinCreateProps, JoinCreateState> {
@@ -18,8 +20,10 @@ class JoinCreate extends React.Component<JoinCreateProps, JoinCreateState> {
     super(props)
     this.state = {
       name: "",
-      join: "",
-      do_join: false
+      join: new URLSearchParams(window.location.search).get("join") || "",
+      do_join: new URLSearchParams(window.location.search).has("join"),
+      size: 2,
+      teams: false
   }
 }
 
@@ -39,6 +43,22 @@ onJoinChange = (event: any) => {
   })
 }
 
+onSizeChange = (event: any) => {
+  const size = parseInt(event.target.value)
+  this.setState((prevState) => {
+    return {
+      size: size,
+      teams: prevState.teams && size >= 4
+    }
+  })
+}
+
+onTeamsChange = (event: any) => {
+  this.setState({
+    teams: event.target.checked
+  })
+}
+
 onCreate = (event: any, hotseat: boolean) => {
   event.preventDefault()
   event.stopPropagation()
@@ -46,7 +66,7 @@ onCreate = (event: any, hotseat: boolean) => {
     toast("Set your name before creating lobby")
     return
   }
-  api("POST", "create", {"size": 2, "hotseat": hotseat}, (e: any) => {
+  api("POST", "create", {"size": this.state.size, "hotseat": hotseat, "teams": this.state.teams}, (e: any) => {
     if (e.target.status !== 201) {
       toast(e.target.response.error)
       return
@@ -119,6 +139,18 @@ inner = () => {
             <span className="cardanim buttonlist">Name</span>
             <input value={this.state.name} onChange={this.onNameChange} placeholder="your name"></input>
           </div>
+          <div className="Flexrow">
+            <span className="cardanim buttonlist">Players</span>
+            <select value={this.state.size} onChange={this.onSizeChange}>
+              <option value={2}>2</option>
+              <option value={4}>4</option>
+              <option value={6}>6</option>
+            </select>
+            <label className="cardanim buttonlist">
+              <input type="checkbox" checked={this.state.teams} disabled={this.state.size < 4} onChange={this.onTeamsChange}></input>
+              Teams
+            </label>
+          </div>
           <div onClick={this.onCreateMP} className="cardanim buttonlist">Multiplayer</div>
           <div onClick={this.onCreateSP} className="cardanim buttonlist">Hotseat</div>
           <div onClick={this.onDoJoin} className="cardanim buttonlist">Join Existing</div>
@@ -51,11 +51,30 @@ class Lobby extends React.Component<LobbyProps, LobbyState> {
         toast(parsed.ping + " asks that you hurry up")
         return
       }
+      if ('restart' in parsed) {
+        toast("The server is restarting, reconnecting shortly")
+        return
+      }
+      if ('error' in parsed) {
+        toast(parsed.error)
+        return
+      }
+      if ('chat' in parsed && parsed.chat.reaction && parsed.chat.name !== this.props.name) {
+        toast(parsed.chat.name + " " + parsed.chat.reaction)
+      }
       this.loadFromServer()
     }
     return socket
   }
 
+  send = (msg: any) => {
+    if (this.ws?.readyState === WebSocket.OPEN) {
+      this.ws.send(JSON.stringify(msg))
+    } else {
+      toast("not connected, try again in a moment")
+    }
+  }
+
   poll() {
     let now = new Date()
     let timeDiff = (now.getTime() - this.last_ws_update.getTime()) / 1000
@@ -95,7 +114,7 @@ class Lobby extends React.Component<LobbyProps, LobbyState> {
         <div className="App-banner">Drunkala || Lobby is {this.props.lobby} || Name is {this.props.name}
           { this.state.room ? <Canvas room={this.state.room} player={this.props.name} load_board={() => {this.loadFromServer()}} /> : <></> }
           <Interaction room={this.state.room} name={this.props.name}></Interaction>
-          <History room={this.state.room} />
+          <History room={this.state.room} send={this.send} />
           {/* <Rules room={this.state.room} name={this.props.name} /> */}
         </div>
       </div>
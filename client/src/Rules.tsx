@@ -77,6 +77,16 @@ class Rules extends React.Component<RulesProps,RulesState> {
                   checked={rule.cycle_value_on_die}
                   disabled={true}
                   style={{height: "20px", width: "20px", marginBottom: "15px"}} />
+                <span>
+                    Drink:
+                </span>
+                <input
+                  type="checkbox"
+                  id="drink"
+                  name="drink"
+                  checked={rule.drink}
+                  disabled={true}
+                  style={{height: "20px", width: "20px", marginBottom: "15px"}} />
                 <br />
                 <span>Min: {rule.min} </span> <span>Max: {rule.max}</span>
             </div>
@@ -0,0 +1,1017 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// API implements the game's endpoints over typed requests and responses. The
+// /api/v1 routes and the original /api endpoints are both thin layers over it.
+type API struct {
+	Rooms       *LockedRooms
+	Profiles    *ProfileStore
+	Tournaments *LockedTournaments
+	Matchmaker  *Matchmaker
+	Upgrader    *websocket.Upgrader
+}
+
+// APIError is an error with the HTTP status it should be reported with.
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func NotFound(what string) error {
+	return &APIError{http.StatusNotFound, "no such " + what}
+}
+
+var (
+	ErrGameNotFinished   = &APIError{http.StatusConflict, "the game is still in progress"}
+	ErrLobbyFull         = &APIError{http.StatusConflict, "lobby is full"}
+	ErrFirstRule         = &APIError{http.StatusForbidden, "the first rule can not be deleted"}
+	ErrTooManyPlayerWS   = &APIError{http.StatusTooManyRequests, "too many connections for this player"}
+	ErrMissingName       = errors.New("name missing")
+	ErrMissingRoomCode   = errors.New("lobby code missing")
+	ErrUnknownQRFormat   = errors.New("unknown image format, use png or svg")
+	ErrNoSeriesToCancel  = &APIError{http.StatusNotFound, "no series in progress"}
+	ErrSimulationTooLong = &APIError{http.StatusServiceUnavailable, "simulation took too long, try fewer games"}
+)
+
+// ErrorStatus is the status an error is reported with. Errors from the game
+// that aren't APIErrors are mapped here, anything else is a bad request.
+func ErrorStatus(err error) int {
+	switch err {
+	case ErrShuttingDown, ErrServerFull:
+		return http.StatusServiceUnavailable
+	case ErrGameEnded, ErrWaitingForPlayers, ErrGameInProgress:
+		return http.StatusConflict
+	case ErrWrongPlayer:
+		return http.StatusForbidden
+	case ErrChatTooFast:
+		return http.StatusTooManyRequests
+	}
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr.Status
+	}
+	return http.StatusBadRequest
+}
+
+// WriteResult writes an error in the JSONError format, or the status and
+// response if there was none. A nil response writes no body.
+func WriteResult(w http.ResponseWriter, status int, res interface{}, err error) {
+	if err != nil {
+		WriteError(w, err.Error(), ErrorStatus(err))
+		return
+	}
+	w.WriteHeader(status)
+	if res != nil {
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+// decodeRequest reads a JSON request body, rejecting fields the request
+// doesn't have. An empty body leaves the request zeroed.
+func decodeRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_BODY_SIZE))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(req); err != nil && err != io.EOF {
+		WriteError(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+type CreateRoomRequest struct {
+	Size     int  `json:"size"`
+	Hotseat  bool `json:"hotseat"`
+	Teams    bool `json:"teams"`
+	Public   bool `json:"public"`
+	WordCode bool `json:"word_code"` // a code of words rather than letters
+}
+
+type CreateRoomResponse struct {
+	Code string `json:"code"`
+}
+
+type JoinRequest struct {
+	Name         string `json:"name"`
+	Profile      bool   `json:"profile"`       // record results to the player's profile
+	ProfileToken string `json:"profile_token"` // issued when the profile was created
+}
+
+type ProfileRequest struct {
+	Name string `json:"name"`
+}
+
+type ProfileResponse struct {
+	Profile *Profile `json:"profile"`
+	Token   string   `json:"token"` // needed to join rooms as the profile, only given out once
+}
+
+type MoveRequest struct {
+	Player string `json:"player"`
+	Index  int    `json:"index"` // hole to play
+}
+
+type PingRequest struct {
+	Name string `json:"name"`
+}
+
+type ChatRequest struct {
+	Name     string `json:"name"`
+	Text     string `json:"text"`
+	Reaction string `json:"reaction"`
+}
+
+type ChatResponse struct {
+	Reactions []string      `json:"reactions"`
+	Messages  []ChatMessage `json:"messages"`
+}
+
+type RuleResponse struct {
+	Id int `json:"id"`
+}
+
+type DeckRequest struct {
+	Cards []Card `json:"cards"`
+}
+
+type StonesRequest struct {
+	Stones []StoneType `json:"stones"`
+}
+
+type HistoryResponse struct {
+	Total   int            `json:"total"`
+	First   int            `json:"first"` // offset of the oldest entry kept
+	Offset  int            `json:"offset"`
+	Entries []HistoryEntry `json:"entries"`
+}
+
+type SimulateRequest struct {
+	Code  string `json:"code"` // simulate a room's setup, overriding size and teams
+	Size  int    `json:"size"`
+	Teams bool   `json:"teams"`
+	Games int    `json:"games"`
+	Rules []Rule `json:"rules"`
+}
+
+type OptOutRequest struct {
+	OptOut bool `json:"opt_out"`
+}
+
+type PartyRequest struct {
+	Enabled     *bool        `json:"enabled"`
+	Alternative *Alternative `json:"alternative"`
+}
+
+type SeriesRequest struct {
+	BestOf int `json:"best_of"`
+}
+
+type TournamentRequest struct {
+	Format       string   `json:"format"`
+	Participants []string `json:"participants"`
+}
+
+type TournamentResponse struct {
+	Code string `json:"code"`
+}
+
+type QueueRequest struct {
+	Name  string `json:"name"`
+	Size  int    `json:"size"`
+	Teams bool   `json:"teams"`
+}
+
+type InviteResponse struct {
+	Code  string `json:"code"`
+	URL   string `json:"url"`
+	QRPNG string `json:"qr_png"`
+	QRSVG string `json:"qr_svg"`
+}
+
+func (api *API) room(code string) (*Room, error) {
+	if code == "" {
+		return nil, ErrMissingRoomCode
+	}
+	room, ok := api.Rooms.Get(code)
+	if !ok {
+		return nil, NotFound("lobby")
+	}
+	return room, nil
+}
+
+func (api *API) CreateRoom(req CreateRoomRequest) (*CreateRoomResponse, error) {
+	api.Rooms.Lock()
+	defer api.Rooms.Unlock()
+
+	if api.Rooms.Closed {
+		return nil, ErrShuttingDown
+	}
+	if len(api.Rooms.Rooms) >= config.Limits.MaxRooms {
+		return nil, ErrServerFull
+	}
+	code, ok := api.Rooms.UniqueCode(req.WordCode)
+	if !ok {
+		return nil, &APIError{http.StatusInternalServerError, "could not create unique room code"}
+	}
+	room, err := NewRoom(code, req.Size, req.Hotseat)
+	if err == nil {
+		err = room.SetTeams(req.Teams)
+	}
+	if err != nil {
+		return nil, err
+	}
+	room.Public = req.Public
+	api.Rooms.Rooms[code] = room
+	return &CreateRoomResponse{Code: code}, nil
+}
+
+func (api *API) ListRooms(size int) []RoomListing {
+	return api.Rooms.PublicRooms(size)
+}
+
+// GetRoom returns the room's state, encoded while the room is locked.
+func (api *API) GetRoom(code string) (json.RawMessage, error) {
+	room, err := api.room(code)
+	if err != nil {
+		return nil, err
+	}
+	room.Lock()
+	defer room.Unlock()
+	return json.Marshal(room)
+}
+
+// JoinRoom adds a player to a room, or returns the room if they are already
+// in it. It reports whether the player is new.
+func (api *API) JoinRoom(code string, req JoinRequest) (json.RawMessage, bool, error) {
+	if req.Name == "" {
+		return nil, false, ErrMissingName
+	}
+	name, err := CleanName(req.Name)
+	if err != nil {
+		return nil, false, err
+	}
+	if req.Profile {
+		if err := api.Profiles.Authenticate(name, req.ProfileToken); err != nil {
+			return nil, false, err
+		}
+	}
+	room, err := api.room(code)
+	if err != nil {
+		return nil, false, err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	if player, _ := room.GetPlayer(name); player != nil {
+		state, err := json.Marshal(room)
+		return state, false, err
+	}
+	if len(room.Players) >= config.Limits.MaxPlayers {
+		return nil, false, ErrLobbyFull
+	}
+	// A tournament match is only for the two players it was opened with
+	if room.Tournament != "" && len(room.Players) >= room.Board.NumPlayers {
+		return nil, false, ErrLobbyFull
+	}
+	room.Players = append(room.Players, &Player{Name: name, Profile: req.Profile, Conns: map[Conn]bool{}})
+	state, err := json.Marshal(room)
+	room.NotifyPlayers()
+	return state, true, err
+}
+
+// Move plays a hole for a player. Finishing a game records it to profiles
+// and any tournament, failures there are logged rather than failing the move.
+func (api *API) Move(code string, req MoveRequest, log *Logger) error {
+	if req.Player == "" {
+		return ErrMissingName
+	}
+	room, err := api.room(code)
+	if err != nil {
+		return err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	if player, _ := room.GetPlayer(req.Player); player == nil && !room.SPMode {
+		return NotFound("player " + req.Player)
+	}
+	if err := room.DoAction(&Action{Code: room.Code, Player: req.Player, Index: req.Index}); err != nil {
+		return err
+	}
+	metrics.Actions.Inc("move")
+	metrics.RecordOutcomes(room, room.History[len(room.History)-1].Outcomes)
+	if room.Board.Finished {
+		api.Profiles.RecordGame(room)
+		if err := api.Tournaments.ReportResult(api.Rooms, room); err != nil {
+			log.Error("could not advance tournament", "error", err)
+		}
+	}
+	room.NotifyPlayers()
+	return nil
+}
+
+// Reset starts a new game once the current one has finished.
+func (api *API) Reset(code string) error {
+	room, err := api.room(code)
+	if err != nil {
+		return err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	if !room.Board.Finished {
+		return ErrGameNotFinished
+	}
+	if err := room.NewGame(); err != nil {
+		return errors.New("error in creating new game")
+	}
+	metrics.Actions.Inc("reset")
+	room.NotifyPlayers()
+	return nil
+}
+
+// Ping nudges the current player on behalf of another.
+func (api *API) Ping(code string, req PingRequest) error {
+	if req.Name == "" {
+		return ErrMissingName
+	}
+	room, err := api.room(code)
+	if err != nil {
+		return err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	if player, _ := room.GetPlayer(req.Name); player == nil {
+		return NotFound("player " + req.Name)
+	}
+
+	for idx, player := range room.Players {
+		if req.Name != player.Name && idx == room.Board.CurrentPlayer {
+			for ws := range player.Conns {
+				if werr := ws.WriteJSON(Ping{req.Name}); werr != nil {
+					err = werr
+				}
+			}
+		}
+	}
+	return err
+}
+
+func (api *API) Chat(code string, req ChatRequest) (*ChatMessage, error) {
+	room, err := api.room(code)
+	if err != nil {
+		return nil, err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	if player, _ := room.GetPlayer(req.Name); player == nil {
+		return nil, NotFound("player " + req.Name)
+	}
+	chat, err := room.Chat(req.Name, req.Text, req.Reaction)
+	if err != nil {
+		return nil, err
+	}
+	room.Broadcast(ChatEvent{chat})
+	return &chat, nil
+}
+
+func (api *API) Messages(code string) (*ChatResponse, error) {
+	room, err := api.room(code)
+	if err != nil {
+		return nil, err
+	}
+	room.RLock()
+	defer room.RUnlock()
+	return &ChatResponse{Reactions, append([]ChatMessage{}, room.Messages...)}, nil
+}
+
+func (api *API) Rules(code string) ([]Rule, error) {
+	room, err := api.room(code)
+	if err != nil {
+		return nil, err
+	}
+	room.RLock()
+	defer room.RUnlock()
+	return append([]Rule{}, room.Rules...), nil
+}
+
+func (api *API) AddRule(code string, rule Rule) (*RuleResponse, error) {
+	rule, err := rule.Clean()
+	if err != nil {
+		return nil, err
+	}
+	room, err := api.room(code)
+	if err != nil {
+		return nil, err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	room.Rules = append(room.Rules, rule)
+	room.NotifyPlayers()
+	return &RuleResponse{Id: len(room.Rules) - 1}, nil
+}
+
+func (api *API) DeleteRule(code string, id int) error {
+	room, err := api.room(code)
+	if err != nil {
+		return err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	if id < 0 || id >= len(room.Rules) {
+		return NotFound("rule")
+	}
+	if id == 0 {
+		return ErrFirstRule
+	}
+	room.Rules = append(room.Rules[:id], room.Rules[id+1:]...)
+	room.NotifyPlayers()
+	return nil
+}
+
+// PutDeck adds a deck to a room, replacing any deck with the same name.
+func (api *API) PutDeck(code string, name string, cards []Card) error {
+	deck, err := NewDeck(name, cards)
+	if err != nil {
+		return err
+	}
+	room, err := api.room(code)
+	if err != nil {
+		return err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	room.Decks[deck.Name] = deck
+	room.NotifyPlayers()
+	return nil
+}
+
+func (api *API) DeleteDeck(code string, name string) error {
+	room, err := api.room(code)
+	if err != nil {
+		return err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	if _, ok := room.Decks[name]; !ok {
+		return NotFound("deck")
+	}
+	delete(room.Decks, name)
+	room.NotifyPlayers()
+	return nil
+}
+
+func (api *API) SetStones(code string, stones []StoneType) error {
+	room, err := api.room(code)
+	if err != nil {
+		return err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	if err := room.SetStones(stones); err != nil {
+		return err
+	}
+	room.NotifyPlayers()
+	return nil
+}
+
+func (api *API) History(code string, offset int, limit int) (*HistoryResponse, error) {
+	room, err := api.room(code)
+	if err != nil {
+		return nil, err
+	}
+	room.RLock()
+	defer room.RUnlock()
+
+	entries, offset := room.HistoryPage(offset, limit)
+	return &HistoryResponse{room.HistoryLen(), room.HistoryDropped, offset, entries}, nil
+}
+
+// Simulate plays bot games for a request, with fewer games allowed than from
+// the command line and a deadline, since they run on the server's time.
+func (api *API) Simulate(ctx context.Context, req SimulateRequest) (*SimulationReport, error) {
+	if req.Games > SIM_MAX_API_GAMES {
+		return nil, fmt.Errorf("can not simulate more than %d games, run drunkala simulate for more", SIM_MAX_API_GAMES)
+	}
+	if req.Games <= 0 {
+		req.Games = SIM_MAX_API_GAMES
+	}
+	cfg := SimulationConfig{Size: req.Size, Teams: req.Teams, Games: req.Games}
+	if req.Rules != nil {
+		cfg.Rules = make([]Rule, len(req.Rules))
+		for i, rule := range req.Rules {
+			var err error
+			if cfg.Rules[i], err = rule.Clean(); err != nil {
+				return nil, fmt.Errorf("rule %d: %s", i, err)
+			}
+		}
+	}
+	if req.Code != "" {
+		room, err := api.room(req.Code)
+		if err != nil {
+			return nil, err
+		}
+		// Simulate with a copy of the room's setup so the room isn't held locked
+		room.Lock()
+		cfg.Size = room.Board.NumPlayers
+		cfg.Teams = room.TeamsMode
+		if cfg.Rules == nil {
+			cfg.Rules = append([]Rule{}, room.Rules...)
+		}
+		cfg.Stones = append([]StoneType{}, room.Stones...)
+		room.Unlock()
+	}
+	ctx, cancel := context.WithTimeout(ctx, SIM_API_TIMEOUT)
+	defer cancel()
+	report, err := Simulate(ctx, cfg)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, ErrSimulationTooLong
+	}
+	return report, err
+}
+
+func (api *API) SetSafety(code string, safety SafetySettings) error {
+	safety, err := safety.Clean()
+	if err != nil {
+		return err
+	}
+	room, err := api.room(code)
+	if err != nil {
+		return err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	room.Safety = safety
+	room.NotifyPlayers()
+	return nil
+}
+
+func (api *API) SetOptOut(code string, name string, optOut bool) error {
+	room, err := api.room(code)
+	if err != nil {
+		return err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	player, _ := room.GetPlayer(name)
+	if player == nil {
+		return NotFound("player " + name)
+	}
+	player.OptOut = optOut
+	room.NotifyPlayers()
+	return nil
+}
+
+func (api *API) SetParty(code string, req PartyRequest) error {
+	var alt Alternative
+	if req.Alternative != nil {
+		var err error
+		if alt, err = req.Alternative.Clean(); err != nil {
+			return err
+		}
+	}
+	room, err := api.room(code)
+	if err != nil {
+		return err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	if req.Alternative != nil {
+		room.Party.Alternative = alt
+	}
+	if req.Enabled != nil {
+		room.Party.Enabled = *req.Enabled
+	}
+	room.NotifyPlayers()
+	return nil
+}
+
+// SetOverride sets the party alternative for one player, or clears it if
+// the alternative is nil.
+func (api *API) SetOverride(code string, name string, override *Alternative) error {
+	var alt Alternative
+	if override != nil {
+		var err error
+		if alt, err = override.Clean(); err != nil {
+			return err
+		}
+	}
+	room, err := api.room(code)
+	if err != nil {
+		return err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	if player, _ := room.GetPlayer(name); player == nil {
+		return NotFound("player " + name)
+	}
+	if override == nil {
+		delete(room.Party.Overrides, name)
+	} else {
+		room.Party.Overrides[name] = alt
+	}
+	room.NotifyPlayers()
+	return nil
+}
+
+func (api *API) StartSeries(code string, bestOf int) error {
+	room, err := api.room(code)
+	if err != nil {
+		return err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	if err := room.StartSeries(bestOf); err != nil {
+		return err
+	}
+	room.NotifyPlayers()
+	return nil
+}
+
+func (api *API) CancelSeries(code string) error {
+	room, err := api.room(code)
+	if err != nil {
+		return err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	if room.Series == nil {
+		return ErrNoSeriesToCancel
+	}
+	room.AddHistory(NewHistoryMessage("Series cancelled!\n" + room.SeriesSummary()))
+	room.Series = nil
+	room.NotifyPlayers()
+	return nil
+}
+
+func (api *API) Leaderboard(n int) []Profile {
+	api.Profiles.RLock()
+	defer api.Profiles.RUnlock()
+	return api.Profiles.Leaderboard(n)
+}
+
+// CreateProfile makes a profile for a name, returning the token that plays
+// as it.
+func (api *API) CreateProfile(req ProfileRequest) (*ProfileResponse, error) {
+	if req.Name == "" {
+		return nil, ErrMissingName
+	}
+	name, err := CleanName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	profile, token, err := api.Profiles.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &ProfileResponse{profile, token}, nil
+}
+
+func (api *API) Profile(name string) (*Profile, error) {
+	api.Profiles.RLock()
+	defer api.Profiles.RUnlock()
+
+	profile, ok := api.Profiles.Get(name)
+	if !ok {
+		return nil, NotFound("profile")
+	}
+	copied := *profile
+	return &copied, nil
+}
+
+func (api *API) CreateTournament(req TournamentRequest) (*TournamentResponse, error) {
+	api.Tournaments.Lock()
+	defer api.Tournaments.Unlock()
+
+	code := ""
+	for i := 0; i < 10000 && code == ""; i++ {
+		code = RandomRoomCode(false)
+		if _, ok := api.Tournaments.Tournaments[code]; ok {
+			code = ""
+		}
+	}
+	if code == "" {
+		return nil, &APIError{http.StatusInternalServerError, "could not create unique tournament code"}
+	}
+
+	t, err := NewTournament(code, req.Format, req.Participants)
+	if err != nil {
+		return nil, err
+	}
+	t.Lock()
+	err = t.OpenRooms(api.Rooms)
+	t.Unlock()
+	if err == ErrShuttingDown || err == ErrServerFull {
+		return nil, err
+	}
+	if err != nil {
+		return nil, &APIError{http.StatusInternalServerError, err.Error()}
+	}
+	api.Tournaments.Tournaments[code] = t
+	return &TournamentResponse{code}, nil
+}
+
+func (api *API) tournament(code string) (*Tournament, error) {
+	api.Tournaments.RLock()
+	defer api.Tournaments.RUnlock()
+	t, ok := api.Tournaments.Tournaments[NormalizeCode(code)]
+	if !ok {
+		return nil, NotFound("tournament")
+	}
+	return t, nil
+}
+
+// GetTournament returns the tournament's state, encoded while it is locked.
+func (api *API) GetTournament(code string) (json.RawMessage, error) {
+	t, err := api.tournament(code)
+	if err != nil {
+		return nil, err
+	}
+	t.RLock()
+	defer t.RUnlock()
+	return json.Marshal(t)
+}
+
+func (api *API) Enqueue(req QueueRequest) (*Ticket, error) {
+	return api.Matchmaker.Enqueue(api.Rooms, req.Name, req.Size, req.Teams)
+}
+
+func (api *API) PollTicket(id string) (*Ticket, error) {
+	ticket, ok := api.Matchmaker.Poll(id)
+	if !ok {
+		return nil, NotFound("ticket")
+	}
+	return &ticket, nil
+}
+
+func (api *API) CancelTicket(id string) error {
+	if !api.Matchmaker.Cancel(id) {
+		return NotFound("ticket waiting in the queue")
+	}
+	return nil
+}
+
+// Invite returns the link and QR codes for joining a room. qrPath is the
+// QR endpoint, which takes the image format as a query parameter.
+func (api *API) Invite(r *http.Request, code string, qrPath string) (*InviteResponse, error) {
+	room, err := api.room(code)
+	if err != nil {
+		return nil, err
+	}
+	room.RLock()
+	defer room.RUnlock()
+	return &InviteResponse{room.Code, JoinURL(r, room.Code), qrPath + "format=png", qrPath + "format=svg"}, nil
+}
+
+// QR draws the QR code of a room's join link, returning its content type.
+func (api *API) QR(r *http.Request, code string, format string) ([]byte, string, error) {
+	room, err := api.room(code)
+	if err != nil {
+		return nil, "", err
+	}
+	room.RLock()
+	url := JoinURL(r, room.Code)
+	room.RUnlock()
+
+	var img []byte
+	switch format {
+	case "", "png":
+		img, err = QRCodePNG(url)
+		format = "image/png"
+	case "svg":
+		img, err = QRCodeSVG(url)
+		format = "image/svg+xml"
+	default:
+		return nil, "", ErrUnknownQRFormat
+	}
+	if err != nil {
+		return nil, "", &APIError{http.StatusInternalServerError, err.Error()}
+	}
+	return img, format, nil
+}
+
+// WriteImage writes an image, or an error in the JSONError format.
+func WriteImage(w http.ResponseWriter, img []byte, contentType string, err error) {
+	if err != nil {
+		WriteError(w, err.Error(), ErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(img)
+}
+
+// Ping is sent to the current player when another player nudges them.
+type Ping struct {
+	Ping string `json:"ping"` // name of the player nudging
+}
+
+type Heartbeat struct {
+	Heartbeat bool `json:"heartbeat"`
+}
+
+// Stream upgrades a request to a websocket that gets the room's updates and
+// heartbeats, and takes chat messages from the player.
+func (api *API) Stream(w http.ResponseWriter, r *http.Request, code string, name string) {
+	room, err := api.room(code)
+	if err != nil {
+		WriteError(w, err.Error(), ErrorStatus(err))
+		return
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	// Checked with the room locked, so shutdown either sees this connection
+	// when it closes the room's or it's refused here
+	api.Rooms.RLock()
+	closed := api.Rooms.Closed
+	api.Rooms.RUnlock()
+	if closed {
+		WriteError(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	player, _ := room.GetPlayer(name)
+	if player == nil {
+		WriteError(w, "no such player "+name, http.StatusNotFound)
+		return
+	}
+	if len(player.Conns) >= config.Limits.MaxPlayerConns {
+		WriteError(w, ErrTooManyPlayerWS.Error(), ErrTooManyPlayerWS.Status)
+		return
+	}
+	if !AcquireConn() {
+		WriteError(w, ErrServerFull.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	ws, err := api.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied with an error
+		RequestLogger(r).Warn("could not upgrade to websocket", "error", err)
+		ReleaseConn()
+		return
+	}
+	player.Conns[ws] = true
+
+	go func() {
+		ticker := time.NewTicker(config.Heartbeat.Duration)
+		defer ticker.Stop()
+		for range ticker.C {
+			// Other writes to the connection happen with the room locked
+			room.RLock()
+			err := ws.WriteJSON(Heartbeat{})
+			room.RUnlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer func() {
+			// Reads fail once the connection is closed from either end
+			room.Lock()
+			ws.Close()
+			delete(player.Conns, ws)
+			room.Unlock()
+			ReleaseConn()
+		}()
+		for {
+			var msg StreamMessage
+			if err := ws.ReadJSON(&msg); err != nil {
+				return
+			}
+			room.Lock()
+			if chat, err := room.Chat(name, msg.Chat, msg.Reaction); err != nil {
+				ws.WriteJSON(JSONError{err.Error()})
+			} else {
+				room.Broadcast(ChatEvent{chat})
+			}
+			room.Unlock()
+		}
+	}()
+}
+
+// Attach adds a connection from within the server, like an SSH session, to a
+// player's connections. The returned function removes it again.
+func (api *API) Attach(code string, name string, conn Conn) (func(), error) {
+	room, err := api.room(code)
+	if err != nil {
+		return nil, err
+	}
+	room.Lock()
+	defer room.Unlock()
+
+	api.Rooms.RLock()
+	closed := api.Rooms.Closed
+	api.Rooms.RUnlock()
+	if closed {
+		return nil, ErrShuttingDown
+	}
+
+	player, _ := room.GetPlayer(name)
+	if player == nil {
+		return nil, NotFound("player " + name)
+	}
+	if len(player.Conns) >= config.Limits.MaxPlayerConns {
+		return nil, ErrTooManyPlayerWS
+	}
+	if !AcquireConn() {
+		return nil, ErrServerFull
+	}
+	player.Conns[conn] = true
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			room.Lock()
+			delete(player.Conns, conn)
+			room.Unlock()
+			conn.Close()
+			ReleaseConn()
+		})
+	}, nil
+}
+
+// TournamentStream upgrades a request to a websocket that gets the
+// tournament's updates.
+func (api *API) TournamentStream(w http.ResponseWriter, r *http.Request, code string) {
+	t, err := api.tournament(code)
+	if err != nil {
+		WriteError(w, err.Error(), ErrorStatus(err))
+		return
+	}
+	// Held until the spectator is registered, so shutdown either sees them
+	// when it closes the tournament's connections or they're refused here
+	t.Lock()
+	api.Rooms.RLock()
+	closed := api.Rooms.Closed
+	api.Rooms.RUnlock()
+	if closed {
+		t.Unlock()
+		WriteError(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if !AcquireConn() {
+		t.Unlock()
+		WriteError(w, ErrServerFull.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	ws, err := api.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		t.Unlock()
+		RequestLogger(r).Warn("could not upgrade to websocket", "error", err)
+		ReleaseConn()
+		return
+	}
+
+	// Registered before reading starts, so a spectator who leaves straight
+	// away isn't added back after the reader has removed them
+	t.Conns[ws] = true
+	if err := ws.WriteJSON(t); err != nil {
+		ws.Close()
+		delete(t.Conns, ws)
+	}
+	t.Unlock()
+
+	go func() {
+		// Nothing is read from spectators, reading just notices them leave
+		for {
+			if _, _, err := ws.NextReader(); err != nil {
+				break
+			}
+		}
+		t.Lock()
+		ws.Close()
+		delete(t.Conns, ws)
+		t.Unlock()
+		ReleaseConn()
+	}()
+}
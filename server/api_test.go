@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStreamRefusedWhileShuttingDown(t *testing.T) {
+	tests := []struct {
+		name   string
+		closed bool
+		player string
+		status int
+	}{
+		{"unknown player", false, "b", http.StatusNotFound},
+		{"shutting down", true, "a", http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room, err := NewRoom("test", 2, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			room.Players = append(room.Players, &Player{Name: "a", Conns: map[Conn]bool{}})
+			api := &API{Rooms: &LockedRooms{Rooms: map[string]*Room{"test": room}, Closed: test.closed}}
+
+			w := httptest.NewRecorder()
+			api.Stream(w, httptest.NewRequest("GET", "/api/v1/rooms/test/players/a/stream", nil), "test", test.player)
+			if w.Code != test.status {
+				t.Errorf("status %d, want %d", w.Code, test.status)
+			}
+			if len(room.Players[0].Conns) != 0 {
+				t.Errorf("connection registered")
+			}
+		})
+	}
+}
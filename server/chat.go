@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	CHAT_BUFFER      = 100              // Messages kept per room, oldest dropped first
+	CHAT_MAX_LENGTH  = 280              // Default limit on characters in one message
+	CHAT_RATE_COUNT  = 5                // Messages a player may send per window
+	CHAT_RATE_WINDOW = 10 * time.Second // Window the rate limit is counted over
+
+	TIMELINE_STATE_ENTRIES = 40 // History and chat lines included in each state response
+
+	TIMELINE_HISTORY  = "history"
+	TIMELINE_CHAT     = "chat"
+	TIMELINE_REACTION = "reaction"
+)
+
+var ErrChatTooFast = errors.New("slow down, you are sending messages too quickly")
+
+// Reactions are the quick reactions players can send without typing.
+var Reactions = []string{"👍", "😂", "🍻", "🥂", "😱", "🔥", "👏", "🤮"}
+
+type ChatMessage struct {
+	Id       int       `json:"id"`
+	Name     string    `json:"name"`
+	Text     string    `json:"text"`
+	Reaction string    `json:"reaction"` // set instead of text for a quick reaction
+	Time     time.Time `json:"time"`
+}
+
+// TimelineEntry is a line of history or chat, as shown to players in the
+// order it happened.
+type TimelineEntry struct {
+	Kind string    `json:"kind"`
+	Name string    `json:"name"` // sender of a chat message or reaction
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+func IsReaction(reaction string) bool {
+	for _, r := range Reactions {
+		if r == reaction {
+			return true
+		}
+	}
+	return false
+}
+
+// Chat adds a message or reaction from a player to the room's chat buffer.
+// Must be called with the room locked.
+func (r *Room) Chat(name string, text string, reaction string) (ChatMessage, error) {
+	var player *Player
+	for _, p := range r.Players {
+		if p.Name == name {
+			player = p
+		}
+	}
+	if player == nil {
+		return ChatMessage{}, errors.New("no player " + name + " in room")
+	}
+
+	text, err := CleanText("chat message", text, config.Limits.MaxChatLength)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	if reaction != "" {
+		if text != "" {
+			return ChatMessage{}, errors.New("send either a message or a reaction")
+		}
+		if !IsReaction(reaction) {
+			return ChatMessage{}, errors.New("unknown reaction " + reaction)
+		}
+	} else if text == "" {
+		return ChatMessage{}, errors.New("chat message is empty")
+	}
+	now := time.Now()
+	recent := []time.Time{}
+	for _, sent := range player.Chats {
+		if now.Sub(sent) < CHAT_RATE_WINDOW {
+			recent = append(recent, sent)
+		}
+	}
+	player.Chats = recent
+	if len(recent) >= CHAT_RATE_COUNT {
+		return ChatMessage{}, ErrChatTooFast
+	}
+	player.Chats = append(player.Chats, now)
+
+	r.ChatCount += 1
+	msg := ChatMessage{Id: r.ChatCount, Name: name, Text: text, Reaction: reaction, Time: now}
+	r.Messages = append(r.Messages, msg)
+	if len(r.Messages) > CHAT_BUFFER {
+		r.Messages = r.Messages[len(r.Messages)-CHAT_BUFFER:]
+	}
+	return msg, nil
+}
+
+// Timeline interleaves the most recent history entries and chat messages by
+// the time they happened, oldest first.
+func (r *Room) Timeline(n int) []TimelineEntry {
+	out := []TimelineEntry{}
+	for i := len(r.History) - 1; i >= 0 && len(out) < n; i-- {
+		if text := r.History[i].Text(); text != "" {
+			out = append(out, TimelineEntry{Kind: TIMELINE_HISTORY, Text: text, Time: r.History[i].Time})
+		}
+	}
+	for i := len(r.Messages) - 1; i >= 0 && i >= len(r.Messages)-n; i-- {
+		msg := r.Messages[i]
+		if msg.Reaction != "" {
+			out = append(out, TimelineEntry{Kind: TIMELINE_REACTION, Name: msg.Name, Text: msg.Reaction, Time: msg.Time})
+		} else {
+			out = append(out, TimelineEntry{Kind: TIMELINE_CHAT, Name: msg.Name, Text: msg.Text, Time: msg.Time})
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	if len(out) > n {
+		out = out[len(out)-n:]
+	}
+	return out
+}
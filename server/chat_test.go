@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func chatRoom(t *testing.T) *Room {
+	t.Helper()
+	room, err := NewRoom("TEST", 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.Players = append(room.Players, &Player{Name: "a"}, &Player{Name: "b"})
+	return room
+}
+
+func TestChat(t *testing.T) {
+	tests := []struct {
+		name     string
+		sender   string
+		text     string
+		reaction string
+		sent     []time.Duration // how long ago the sender's earlier messages were
+		err      error           // nil for any error when ok is false
+		ok       bool
+	}{
+		{"message", "a", "hi", "", nil, nil, true},
+		{"reaction", "a", "", "🍻", nil, nil, true},
+		{"unknown reaction", "a", "", "🦄", nil, nil, false},
+		{"message and reaction", "a", "hi", "🍻", nil, nil, false},
+		{"empty", "a", "  ", "", nil, nil, false},
+		{"not in the room", "c", "hi", "", nil, nil, false},
+		{"under the rate", "a", "hi", "", []time.Duration{time.Second, time.Second, time.Second, time.Second}, nil, true},
+		{"over the rate", "a", "hi", "", []time.Duration{time.Second, time.Second, time.Second, time.Second, time.Second}, ErrChatTooFast, false},
+		{"rate per player", "b", "hi", "", nil, nil, true},
+		{"rate window passed", "a", "hi", "", []time.Duration{CHAT_RATE_WINDOW, CHAT_RATE_WINDOW, CHAT_RATE_WINDOW, CHAT_RATE_WINDOW, time.Second}, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room := chatRoom(t)
+			// a has always sent five messages a second ago, unless the test says otherwise
+			sent := test.sent
+			if test.sender != "a" {
+				sent = []time.Duration{time.Second, time.Second, time.Second, time.Second, time.Second}
+			}
+			for _, ago := range sent {
+				room.Players[0].Chats = append(room.Players[0].Chats, time.Now().Add(-ago))
+			}
+			msg, err := room.Chat(test.sender, test.text, test.reaction)
+			if (err == nil) != test.ok || (test.err != nil && err != test.err) {
+				t.Fatalf("got error %v, want ok %v (%v)", err, test.ok, test.err)
+			}
+			if err != nil {
+				if len(room.Messages) != 0 {
+					t.Errorf("refused message kept: %+v", room.Messages)
+				}
+				return
+			}
+			if msg.Id != 1 || msg.Name != test.sender || msg.Text != test.text || msg.Reaction != test.reaction {
+				t.Errorf("sent %+v", msg)
+			}
+			if !reflect.DeepEqual(room.Messages, []ChatMessage{msg}) {
+				t.Errorf("messages %+v, want just %+v", room.Messages, msg)
+			}
+		})
+	}
+}
+
+func TestChatBuffer(t *testing.T) {
+	room := chatRoom(t)
+	for i := 1; i <= CHAT_BUFFER+5; i++ {
+		room.Players[0].Chats = nil
+		if _, err := room.Chat("a", fmt.Sprint(i), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(room.Messages) != CHAT_BUFFER {
+		t.Fatalf("%d messages kept, want %d", len(room.Messages), CHAT_BUFFER)
+	}
+	first, last := room.Messages[0], room.Messages[len(room.Messages)-1]
+	if first.Id != 6 || first.Text != "6" || last.Id != CHAT_BUFFER+5 {
+		t.Errorf("kept messages %d to %d, want 6 to %d", first.Id, last.Id, CHAT_BUFFER+5)
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	start := time.Now()
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+	history := func(text string, s int) HistoryEntry {
+		entry := NewHistoryMessage(text)
+		entry.Time = at(s)
+		return entry
+	}
+	room := chatRoom(t)
+	room.History = []HistoryEntry{history("h1", 1), {Time: at(2)}, history("h3", 4), history("h4", 6)}
+	room.Messages = []ChatMessage{
+		{Id: 1, Name: "a", Text: "c1", Time: at(0)},
+		{Id: 2, Name: "b", Reaction: "🍻", Time: at(3)},
+		{Id: 3, Name: "a", Text: "c3", Time: at(4)},
+	}
+	entry := func(kind string, name string, text string, s int) TimelineEntry {
+		return TimelineEntry{Kind: kind, Name: name, Text: text, Time: at(s)}
+	}
+	all := []TimelineEntry{
+		entry(TIMELINE_CHAT, "a", "c1", 0),
+		entry(TIMELINE_HISTORY, "", "h1", 1),
+		entry(TIMELINE_REACTION, "b", "🍻", 3),
+		entry(TIMELINE_CHAT, "a", "c3", 4), // chat first when they happened at the same time
+		entry(TIMELINE_HISTORY, "", "h3", 4),
+		entry(TIMELINE_HISTORY, "", "h4", 6),
+	}
+	tests := []struct {
+		n    int
+		want []TimelineEntry
+	}{
+		{0, []TimelineEntry{}},
+		{1, all[5:]},
+		{3, all[3:]},
+		{6, all},
+		{40, all},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.n), func(t *testing.T) {
+			if got := room.Timeline(test.n); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
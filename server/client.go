@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	CLIENT_DEFAULT_SERVER = "http://localhost:4000"
+	CLIENT_TIMEOUT        = 10 * time.Second
+)
+
+// ClientRoom is the part of a room's state the terminal client shows.
+type ClientRoom struct {
+	Code       string     `json:"code"`
+	Players    []*Player  `json:"players"`
+	Board      *GameBoard `json:"board"`
+	SPMode     bool       `json:"sp_mode"`
+	TeamsMode  bool       `json:"teams_mode"`
+	GameNumber int        `json:"game_number"`
+	HistoryLen int        `json:"history_len"`
+}
+
+// GameClient is what the terminal plays a room through: a server's HTTP API,
+// or the API itself for SSH sessions.
+type GameClient interface {
+	Join(code string, name string) (*ClientRoom, error)
+	State(code string) (*ClientRoom, error)
+	Move(code string, name string, index int) error
+	Reset(code string, name string) error
+	History(code string, offset int) (*HistoryResponse, error)
+	Chat(code string, name string, text string) error
+	Stream(code string, name string) (RoomStream, error)
+}
+
+// RoomStream is a player's connection to a room, as read by a client.
+type RoomStream interface {
+	ReadJSON(v interface{}) error
+	Close() error
+}
+
+// Client plays on a drunkala server through its /api endpoints.
+type Client struct {
+	Server string // base URL, like http://localhost:4000
+	HTTP   *http.Client
+}
+
+func NewClient(server string) *Client {
+	return &Client{Server: strings.TrimSuffix(server, "/"), HTTP: &http.Client{Timeout: CLIENT_TIMEOUT}}
+}
+
+// post sends a request to an endpoint and decodes the response into res,
+// turning error responses into errors.
+func (c *Client) post(endpoint string, req interface{}, res interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTP.Post(c.Server+"/api/"+endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e JSONError
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == "" {
+			return errors.New(resp.Status)
+		}
+		return errors.New(e.Error)
+	}
+	if res == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+func (c *Client) Create(size int, hotseat bool) (string, error) {
+	var res CreateRoomResponse
+	err := c.post("create", struct {
+		Size     int
+		Hotseat  bool
+		WordCode bool
+	}{size, hotseat, true}, &res)
+	return res.Code, err
+}
+
+func (c *Client) Join(code string, name string) (*ClientRoom, error) {
+	var room ClientRoom
+	err := c.post("join", struct {
+		Code string
+		Name string
+	}{code, name}, &room)
+	return &room, err
+}
+
+func (c *Client) State(code string) (*ClientRoom, error) {
+	var room ClientRoom
+	err := c.post("state", struct{ Code string }{code}, &room)
+	return &room, err
+}
+
+func (c *Client) Move(code string, name string, index int) error {
+	return c.post("input", Action{Code: code, Player: name, Index: index}, nil)
+}
+
+// Reset starts a new game once the current one has finished.
+func (c *Client) Reset(code string, name string) error {
+	return c.post("input", Action{Code: code, Player: name, Reset: true}, nil)
+}
+
+func (c *Client) History(code string, offset int) (*HistoryResponse, error) {
+	var res HistoryResponse
+	err := c.post("history", struct {
+		Code   string
+		Offset int
+		Limit  int
+	}{code, offset, HISTORY_MAX_PAGE_SIZE}, &res)
+	return &res, err
+}
+
+func (c *Client) Chat(code string, name string, text string) error {
+	return c.post("chat", struct {
+		Code string
+		Name string
+		Text string
+	}{code, name, text}, nil)
+}
+
+// Stream opens the player's websocket to the room.
+func (c *Client) Stream(code string, name string) (RoomStream, error) {
+	u, err := url.Parse(c.Server + "/api/stream")
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	u.RawQuery = url.Values{"code": {code}, "name": {name}}.Encode()
+
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = CLIENT_TIMEOUT
+	ws, resp, err := dialer.Dial(u.String(), nil)
+	if err != nil {
+		if resp != nil {
+			defer resp.Body.Close()
+			var e JSONError
+			if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+				return nil, errors.New(e.Error)
+			}
+		}
+		return nil, err
+	}
+	return ws, nil
+}
+
+// RunClientCommand implements the "play" subcommand, a terminal client for
+// playing without a browser.
+func RunClientCommand(args []string) error {
+	fs := flag.NewFlagSet("play", flag.ContinueOnError)
+	server := fs.String("server", CLIENT_DEFAULT_SERVER, "URL of the server")
+	name := fs.String("name", os.Getenv("USER"), "player name")
+	code := fs.String("code", "", "room code to join, a new room is created without one")
+	size := fs.Int("size", 2, "board size of a new room")
+	hotseat := fs.Bool("hotseat", false, "play every seat of a new room from this terminal")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return ErrMissingName
+	}
+
+	client := NewClient(*server)
+	if *code == "" {
+		created, err := client.Create(*size, *hotseat)
+		if err != nil {
+			return err
+		}
+		*code = created
+	}
+	return NewTerminal(client, os.Stdin, os.Stdout).Play(*code, *name)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// CODE_ALPHABET leaves out characters that are easily confused when read out
+// loud or in a bad font: i, l, o, 0 and 1.
+const CODE_ALPHABET = "abcdefghjkmnpqrstuvwxyz23456789"
+
+const (
+	CODE_LENGTH = 6 // Default length of room codes
+	CODE_WORDS  = 3
+	QR_SIZE     = 256
+)
+
+var codeWords = []string{
+	"amber", "apple", "arrow", "badge", "bagel", "banjo", "beach", "berry",
+	"bison", "blaze", "bloom", "brick", "cabin", "camel", "candy", "cargo",
+	"cedar", "chalk", "cider", "cloud", "cobra", "comet", "coral", "crane",
+	"daisy", "delta", "disco", "dream", "eagle", "ember", "fable", "ferry",
+	"flame", "flute", "frost", "gecko", "ghost", "giant", "grape", "grove",
+	"hazel", "honey", "igloo", "ivory", "jelly", "jewel", "koala", "lemon",
+	"lilac", "llama", "lotus", "mango", "maple", "medal", "melon", "mocha",
+	"moose", "noble", "oasis", "olive", "orbit", "otter", "panda", "peach",
+	"pearl", "piano", "pixel", "plaza", "polar", "pride", "quail", "radar",
+	"raven", "robin", "rocket", "royal", "salsa", "shark", "shell", "sugar",
+	"tango", "tiger", "toast", "topaz", "tulip", "ultra", "vapor", "velvet",
+	"viola", "waffle", "whale", "willow", "zebra", "zesty",
+}
+
+// RandomRoomCode returns a code made of unambiguous characters, or of a few
+// short words joined with dashes.
+func RandomRoomCode(words bool) string {
+	if words {
+		out := make([]string, CODE_WORDS)
+		for i := range out {
+			out[i] = codeWords[rand.Intn(len(codeWords))]
+		}
+		return strings.Join(out, "-")
+	}
+	b := make([]byte, config.Limits.CodeLength)
+	for i := range b {
+		b[i] = CODE_ALPHABET[rand.Intn(len(CODE_ALPHABET))]
+	}
+	return string(b)
+}
+
+// NormalizeCode turns a code as typed by a player into the form it is stored
+// in, so case and spaces between words don't matter.
+func NormalizeCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.Join(strings.Fields(code), "-")
+}
+
+// JoinURL is the link that opens the client with a room code filled in. It
+// starts with the configured public URL, or else the address the request was
+// made to.
+func JoinURL(r *http.Request, code string) string {
+	if config.PublicURL != "" {
+		return fmt.Sprintf("%s/?join=%s", strings.TrimRight(config.PublicURL, "/"), code)
+	}
+	return fmt.Sprintf("%s/?join=%s", RequestOrigin(r), code)
+}
+
+// QRCodeSVG draws a QR code as an SVG with one square per dark module.
+func QRCodeSVG(content string) ([]byte, error) {
+	qr, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	bitmap := qr.Bitmap()
+	n := len(bitmap)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, n, n)
+	fmt.Fprintf(&sb, `<rect width="%d" height="%d" fill="#ffffff"/>`, n, n)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="1" height="1" fill="#000000"/>`, x, y)
+			}
+		}
+	}
+	sb.WriteString("</svg>")
+	return []byte(sb.String()), nil
+}
+
+func QRCodePNG(content string) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, QR_SIZE)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJoinURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		publicURL  string
+		trustProxy bool
+		headers    map[string]string
+		want       string
+	}{
+		{"request host", "", false, nil, "http://game.test/?join=abc"},
+		{"forwarded headers ignored", "", false, map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.test"}, "http://game.test/?join=abc"},
+		{"trusted proxy", "", true, map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.test, drunkala.test"}, "https://drunkala.test/?join=abc"},
+		{"bad forwarded scheme", "", true, map[string]string{"X-Forwarded-Proto": "javascript"}, "http://game.test/?join=abc"},
+		{"public url", "https://drunkala.test/", true, map[string]string{"X-Forwarded-Host": "evil.test"}, "https://drunkala.test/?join=abc"},
+	}
+	defer func(trust bool, public string) { config.TrustProxy, config.PublicURL = trust, public }(config.TrustProxy, config.PublicURL)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.TrustProxy, config.PublicURL = test.trustProxy, test.publicURL
+			r := httptest.NewRequest("GET", "http://game.test/api/v1/rooms/abc/qr", nil)
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			if got := JoinURL(r, "abc"); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written as a string like "500ms" in config files.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// Defaults for Limits, other than those kept with the code they limit like
+// CODE_LENGTH. Request bodies are always capped at MAX_BODY_SIZE.
+const (
+	MAX_ROOMS            = 2000
+	MAX_PLAYERS_PER_ROOM = 16 // Seats plus anyone else who joins to watch
+	MAX_CONNS            = 5000
+	MAX_PLAYER_CONNS     = 4 // A player with the game open in a few tabs
+	MAX_BODY_SIZE        = 1 << 20
+)
+
+type Limits struct {
+	CodeLength     int `json:"code_length"`
+	MaxNameLength  int `json:"max_name_length"`
+	MaxTextLength  int `json:"max_text_length"`
+	MaxChatLength  int `json:"max_chat_length"`
+	MaxRooms       int `json:"max_rooms"`
+	MaxPlayers     int `json:"max_players"` // per room
+	MaxConns       int `json:"max_conns"`
+	MaxPlayerConns int `json:"max_player_conns"`
+	MaxHistory     int `json:"max_history"` // history entries of earlier games a room keeps
+}
+
+// Config holds the server settings. Defaults are overridden by a config file,
+// then environment variables, then command line flags.
+type Config struct {
+	Listen          string               `json:"listen"` // host:port to serve on
+	StaticDir       string               `json:"static_dir"`
+	AllowedOrigins  []string             `json:"allowed_origins"` // "*" allows any origin
+	TrustProxy      bool                 `json:"trust_proxy"`     // take client addresses and join links from X-Forwarded-* headers
+	PublicURL       string               `json:"public_url"`      // base of join links, the request's host when empty
+	Heartbeat       Duration             `json:"heartbeat"`
+	RoomTTL         Duration             `json:"room_ttl"` // idle rooms are closed after this long, 0 keeps them forever
+	ShutdownTimeout Duration             `json:"shutdown_timeout"`
+	Profiles        string               `json:"profiles"`    // file profiles are saved to
+	WordFilter      string               `json:"word_filter"` // file of filtered words
+	RulePack        string               `json:"rule_pack"`   // file of rules new rooms start with
+	LogLevel        string               `json:"log_level"`
+	LogFormat       string               `json:"log_format"`
+	AccessLog       bool                 `json:"access_log"`
+	Limits          Limits               `json:"limits"`
+	RateLimits      map[string]RateLimit `json:"rate_limits"`  // keyed by endpoint, see NewDefaultRateLimits
+	SSHListen       string               `json:"ssh_listen"`   // host:port to serve SSH sessions on, empty to not serve them
+	SSHHostKey      string               `json:"ssh_host_key"` // file of the SSH host key, generated if missing
+
+	rules []Rule
+}
+
+func NewDefaultConfig() *Config {
+	return &Config{
+		Listen:          "0.0.0.0:4000",
+		StaticDir:       "/home/apps/drunkala/client/build",
+		AllowedOrigins:  []string{},
+		Heartbeat:       Duration{500 * time.Millisecond},
+		RoomTTL:         Duration{24 * time.Hour},
+		ShutdownTimeout: Duration{SHUTDOWN_TIMEOUT},
+		LogLevel:        "info",
+		LogFormat:       "text",
+		Limits: Limits{
+			CodeLength:     CODE_LENGTH,
+			MaxNameLength:  MAX_NAME_LENGTH,
+			MaxTextLength:  MAX_TEXT_LENGTH,
+			MaxChatLength:  CHAT_MAX_LENGTH,
+			MaxRooms:       MAX_ROOMS,
+			MaxPlayers:     MAX_PLAYERS_PER_ROOM,
+			MaxConns:       MAX_CONNS,
+			MaxPlayerConns: MAX_PLAYER_CONNS,
+			MaxHistory:     HISTORY_MAX_ENTRIES,
+		},
+		RateLimits: NewDefaultRateLimits(),
+	}
+}
+
+// config is the running server's configuration.
+var config = NewDefaultConfig()
+
+// LoadConfig reads the config file named by -config or CONFIG, then applies
+// environment variables and flags over it, and validates the result.
+func LoadConfig(args []string) (*Config, error) {
+	cfg := NewDefaultConfig()
+
+	fs := flag.NewFlagSet("drunkala", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG"), "JSON config file")
+	listen := fs.String("listen", "", "address to serve on, host:port")
+	static := fs.String("static", "", "directory of the built client")
+	origins := fs.String("origins", "", "comma separated origins allowed to use the API, * for any")
+	heartbeat := fs.Duration("heartbeat", 0, "interval between websocket heartbeats")
+	ttl := fs.Duration("room-ttl", 0, "close rooms idle for this long, 0 keeps them forever")
+	profiles := fs.String("profiles", "", "file to save player profiles to")
+	words := fs.String("word-filter", "", "file of words to filter, one per line")
+	rules := fs.String("rules", "", "file of rules new rooms start with")
+	level := fs.String("log-level", "", "debug, info, warn or error")
+	format := fs.String("log-format", "", "text or json")
+	access := fs.Bool("access-log", false, "log every API request")
+	proxy := fs.Bool("trust-proxy", false, "take client addresses and join links from X-Forwarded-* headers")
+	public := fs.String("public-url", "", "base of join links, like https://drunkala.example.com")
+	sshListen := fs.String("ssh-listen", "", "address to serve SSH sessions on, host:port")
+	sshKey := fs.String("ssh-host-key", "", "file of the SSH host key, generated if missing")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		data, err := ioutil.ReadFile(*path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("could not read config %s: %s", *path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Listen = *listen
+		case "static":
+			cfg.StaticDir = *static
+		case "origins":
+			cfg.AllowedOrigins = splitList(*origins)
+		case "heartbeat":
+			cfg.Heartbeat = Duration{*heartbeat}
+		case "room-ttl":
+			cfg.RoomTTL = Duration{*ttl}
+		case "profiles":
+			cfg.Profiles = *profiles
+		case "word-filter":
+			cfg.WordFilter = *words
+		case "rules":
+			cfg.RulePack = *rules
+		case "log-level":
+			cfg.LogLevel = *level
+		case "log-format":
+			cfg.LogFormat = *format
+		case "access-log":
+			cfg.AccessLog = *access
+		case "trust-proxy":
+			cfg.TrustProxy = *proxy
+		case "public-url":
+			cfg.PublicURL = *public
+		case "ssh-listen":
+			cfg.SSHListen = *sshListen
+		case "ssh-host-key":
+			cfg.SSHHostKey = *sshKey
+		}
+	})
+
+	return cfg, cfg.Validate()
+}
+
+func splitList(s string) []string {
+	out := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// applyEnv overrides settings from environment variables. PORT is what
+// Heroku sets, and NOCORS is kept from before origins could be configured.
+func (c *Config) applyEnv() error {
+	if port := os.Getenv("PORT"); port != "" {
+		c.Listen = "0.0.0.0:" + port
+	}
+	if listen := os.Getenv("LISTEN"); listen != "" {
+		c.Listen = listen
+	}
+	if dir := os.Getenv("STATIC_DIR"); dir != "" {
+		c.StaticDir = dir
+	}
+	if os.Getenv("NOCORS") != "" {
+		c.AllowedOrigins = []string{"*"}
+	}
+	if origins := os.Getenv("ALLOWED_ORIGINS"); origins != "" {
+		c.AllowedOrigins = splitList(origins)
+	}
+	for name, d := range map[string]*Duration{"HEARTBEAT": &c.Heartbeat, "ROOM_TTL": &c.RoomTTL, "SHUTDOWN_TIMEOUT": &c.ShutdownTimeout} {
+		if s := os.Getenv(name); s != "" {
+			parsed, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			d.Duration = parsed
+		}
+	}
+	for name, s := range map[string]*string{"PROFILES": &c.Profiles, "WORD_FILTER": &c.WordFilter, "RULE_PACK": &c.RulePack, "LOG_LEVEL": &c.LogLevel, "LOG_FORMAT": &c.LogFormat, "SSH_LISTEN": &c.SSHListen, "SSH_HOST_KEY": &c.SSHHostKey, "PUBLIC_URL": &c.PublicURL} {
+		if v := os.Getenv(name); v != "" {
+			*s = v
+		}
+	}
+	if os.Getenv("ACCESS_LOG") != "" {
+		c.AccessLog = true
+	}
+	if os.Getenv("TRUST_PROXY") != "" {
+		c.TrustProxy = true
+	}
+	return nil
+}
+
+// Validate checks the settings.
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("listen address %s: %s", c.Listen, err)
+	}
+	if c.SSHListen != "" {
+		if _, _, err := net.SplitHostPort(c.SSHListen); err != nil {
+			return fmt.Errorf("ssh listen address %s: %s", c.SSHListen, err)
+		}
+	}
+	if c.StaticDir != "" {
+		if info, err := os.Stat(c.StaticDir); err == nil && !info.IsDir() {
+			return errors.New("static dir " + c.StaticDir + " is not a directory")
+		}
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return errors.New("allowed origin " + origin + " should look like https://example.com")
+		}
+	}
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+			return errors.New("public url " + c.PublicURL + " should look like https://example.com")
+		}
+	}
+	if c.Heartbeat.Duration < 100*time.Millisecond {
+		return errors.New("heartbeat must be at least 100ms")
+	}
+	if c.RoomTTL.Duration < 0 {
+		return errors.New("room ttl can not be negative")
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		return errors.New("shutdown timeout must be positive")
+	}
+	if _, err := ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return errors.New("log format must be text or json")
+	}
+	if c.Limits.CodeLength < 4 || c.Limits.CodeLength > 32 {
+		return errors.New("code length must be between 4 and 32")
+	}
+	if c.Limits.MaxNameLength < 1 || c.Limits.MaxTextLength < 1 || c.Limits.MaxChatLength < 1 {
+		return errors.New("length limits must be positive")
+	}
+	if c.Limits.MaxRooms < 1 || c.Limits.MaxPlayers < 1 || c.Limits.MaxConns < 1 || c.Limits.MaxPlayerConns < 1 || c.Limits.MaxHistory < 1 {
+		return errors.New("room, player, connection and history limits must be positive")
+	}
+	for name, limit := range c.RateLimits {
+		if _, ok := NewDefaultRateLimits()[name]; !ok {
+			return errors.New("rate limit for unknown endpoint " + name)
+		}
+		for _, b := range []*Bucket{limit.IP, limit.Player} {
+			if b == nil {
+				continue
+			}
+			if err := b.Validate(); err != nil {
+				return fmt.Errorf("rate limit %s: %s", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// NewRules returns the rules a new room starts with.
+func (c *Config) NewRules() []Rule {
+	if c.rules == nil {
+		return NewDefaultRules()
+	}
+	return append([]Rule{}, c.rules...)
+}
+
+// Apply makes this the running configuration, setting up logging, the word
+// filter and the rule pack. The rule pack is checked against the configured
+// limits so it is loaded last.
+func (c *Config) Apply() error {
+	level, err := ParseLevel(c.LogLevel)
+	if err != nil {
+		return err
+	}
+	logSettings.Level = level
+	logSettings.JSON = c.LogFormat == "json"
+	logSettings.AccessLog = c.AccessLog
+
+	if c.WordFilter != "" {
+		words, err := LoadWordFilter(c.WordFilter)
+		if err != nil {
+			return err
+		}
+		SetWordFilter(words)
+	}
+	config = c
+
+	if c.RulePack != "" {
+		data, err := ioutil.ReadFile(c.RulePack)
+		if err != nil {
+			return err
+		}
+		rules := []Rule{}
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return fmt.Errorf("could not read rule pack %s: %s", c.RulePack, err)
+		}
+		for i := range rules {
+			if rules[i], err = rules[i].Clean(); err != nil {
+				return fmt.Errorf("rule %d in rule pack: %s", i, err)
+			}
+		}
+		c.rules = rules
+	}
+	return nil
+}
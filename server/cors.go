@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	CORS_METHODS = "GET, POST, OPTIONS"
+	CORS_HEADERS = "Accept, Content-Type, Content-Length, Authorization"
+	CORS_MAX_AGE = "600" // Seconds browsers may cache a preflight response
+)
+
+// normalizeOrigin lower cases the scheme and host of an origin and drops any
+// trailing slash so origins can be compared as strings.
+func normalizeOrigin(origin string) string {
+	u, err := url.Parse(strings.TrimSpace(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host)
+}
+
+// RequestOrigin is the scheme and host a request was made to. Forwarded
+// headers can be set by anyone, so they're only believed from a trusted proxy.
+func RequestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if config.TrustProxy {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		// Like X-Forwarded-For, the last entry is the one the proxy added
+		if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+			parts := strings.Split(forwarded, ",")
+			host = strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+	return scheme + "://" + host
+}
+
+// OriginAllowed applies the origin policy to a request. Requests without an
+// Origin header don't come from a browser page and requests from the page the
+// server itself serves, the same scheme and host, are always allowed. Anything
+// else has to be in the configured allowlist.
+func OriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	normalized := normalizeOrigin(origin)
+	if normalized == "" {
+		return false
+	}
+	if normalized == normalizeOrigin(RequestOrigin(r)) {
+		return true
+	}
+	for _, allowed := range config.AllowedOrigins {
+		if allowed == "*" || normalizeOrigin(allowed) == normalized {
+			return true
+		}
+	}
+	return false
+}
+
+// corsHeaders applies the origin policy and sets the headers every API
+// response has. It returns false when the request has been refused.
+func corsHeaders(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "Origin")
+
+	if !OriginAllowed(r) {
+		WriteError(w, "origin "+r.Header.Get("Origin")+" is not allowed", http.StatusForbidden)
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	w.Header().Set("Access-Control-Allow-Headers", CORS_HEADERS)
+	return true
+}
+
+// preflight answers a CORS preflight request once the allowed methods are set.
+func preflight(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Max-Age", CORS_MAX_AGE)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed string) {
+	w.Header().Set("Allow", allowed)
+	WriteError(w, "method "+r.Method+" not allowed", http.StatusMethodNotAllowed)
+}
+
+// CheckOrigin is the websocket upgrader's origin check, so a page on another
+// site can't open a stream with a player's name and read their game.
+func CheckOrigin(r *http.Request) bool {
+	if OriginAllowed(r) {
+		return true
+	}
+	RequestLogger(r).Warn("rejected websocket from origin", "origin", r.Header.Get("Origin"))
+	return false
+}
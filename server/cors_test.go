@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string // the request was made to
+		origin     string
+		allowed    []string
+		trustProxy bool
+		headers    map[string]string
+		want       bool
+	}{
+		{"no origin", "http://game.test/api/create", "", nil, false, nil, true},
+		{"same origin", "http://game.test/api/create", "http://game.test", nil, false, nil, true},
+		{"same origin in another case", "http://game.test/api/create", "HTTP://Game.Test/", nil, false, nil, true},
+		{"same host over https", "https://game.test/api/create", "https://game.test", nil, false, nil, true},
+		{"same host, other scheme", "http://game.test/api/create", "https://game.test", nil, false, nil, false},
+		{"same host, other port", "http://game.test/api/create", "http://game.test:8080", nil, false, nil, false},
+		{"https behind a trusted proxy", "http://game.test/api/create", "https://game.test", nil, true, map[string]string{"X-Forwarded-Proto": "https"}, true},
+		{"https behind an untrusted proxy", "http://game.test/api/create", "https://game.test", nil, false, map[string]string{"X-Forwarded-Proto": "https"}, false},
+		{"allowlisted", "http://game.test/api/create", "https://client.test", []string{"https://client.test/"}, false, nil, true},
+		{"allowlisted with another scheme", "http://game.test/api/create", "http://client.test", []string{"https://client.test"}, false, nil, false},
+		{"not allowlisted", "http://game.test/api/create", "https://evil.test", []string{"https://client.test"}, false, nil, false},
+		{"any origin", "http://game.test/api/create", "https://evil.test", []string{"*"}, false, nil, true},
+		{"not an origin", "http://game.test/api/create", "game.test", nil, false, nil, false},
+		{"null origin", "http://game.test/api/create", "null", nil, false, nil, false},
+	}
+	defer func(trust bool, allowed []string) { config.TrustProxy, config.AllowedOrigins = trust, allowed }(config.TrustProxy, config.AllowedOrigins)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.TrustProxy, config.AllowedOrigins = test.trustProxy, test.allowed
+			// httptest sets TLS for https URLs
+			r := httptest.NewRequest(http.MethodPost, test.url, nil)
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			if got := OriginAllowed(r); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		origin string
+		status int
+	}{
+		{"original endpoint", "/api/create", "https://client.test", http.StatusNoContent},
+		{"original endpoint from another origin", "/api/create", "https://evil.test", http.StatusForbidden},
+		{"versioned endpoint", "/api/v1/rooms", "https://client.test", http.StatusNoContent},
+		{"versioned endpoint from another origin", "/api/v1/rooms", "https://evil.test", http.StatusForbidden},
+	}
+	defer func(allowed []string) { config.AllowedOrigins = allowed }(config.AllowedOrigins)
+	config.AllowedOrigins = []string{"https://client.test"}
+	api := newTestAPI(t)
+	handlers := map[string]http.HandlerFunc{
+		"/api/create":   HandleCreate(api),
+		"/api/v1/rooms": NewV1Router(api, NewRateLimits(nil)).ServeHTTP,
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodOptions, "http://game.test"+test.path, nil)
+			r.Header.Set("Origin", test.origin)
+			r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			handlers[test.path](w, r)
+			if w.Code != test.status {
+				t.Fatalf("status %d, want %d", w.Code, test.status)
+			}
+			allowOrigin := w.Header().Get("Access-Control-Allow-Origin")
+			if test.status == http.StatusNoContent {
+				if allowOrigin != test.origin || w.Header().Get("Access-Control-Allow-Methods") == "" || w.Header().Get("Access-Control-Max-Age") != CORS_MAX_AGE {
+					t.Errorf("preflight headers %v", w.Header())
+				}
+			} else if allowOrigin != "" {
+				t.Errorf("refused origin allowed by %q", allowOrigin)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+//go:embed decks/*.json
+var deckFiles embed.FS
+
+const (
+	DECK_ANY_LEVEL   = -1
+	DECK_VALUE_LEVEL = -2 // Draw a card whose level is the value the rule fired with
+	DECK_ROLL_LEVEL  = -3 // Roll a die for the level of the card
+)
+
+type Card struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+}
+
+type Deck struct {
+	Name  string `json:"name"`
+	Cards []Card `json:"cards"`
+	drawn map[int]bool
+}
+
+// DeckInfo is what a room exposes about its decks, so the cards themselves
+// are not spoiled in every state response.
+type DeckInfo struct {
+	Name      string `json:"name"`
+	Levels    []int  `json:"levels"`
+	Size      int    `json:"size"`
+	Remaining int    `json:"remaining"`
+}
+
+func NewDeck(name string, cards []Card) (*Deck, error) {
+	name, err := checkText("deck name", name, MAX_LABEL_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.New("deck name missing")
+	}
+	if len(cards) == 0 {
+		return nil, errors.New("deck has no cards")
+	}
+	cards = append([]Card{}, cards...)
+	for i := range cards {
+		card := &cards[i]
+		if card.Text, err = CleanText("card text", card.Text, config.Limits.MaxTextLength); err != nil {
+			return nil, err
+		}
+		if card.Text == "" {
+			return nil, errors.New("deck has a card with no text")
+		}
+		if card.Level < 0 {
+			return nil, errors.New("deck has a card with a negative level")
+		}
+	}
+	return &Deck{Name: name, Cards: cards, drawn: map[int]bool{}}, nil
+}
+
+var defaultDecks struct {
+	sync.Once
+	decks map[string]*Deck
+}
+
+// LoadDefaultDecks returns fresh copies of the embedded decks, which are only
+// read the first time.
+func LoadDefaultDecks() map[string]*Deck {
+	defaultDecks.Do(func() {
+		defaultDecks.decks = loadDefaultDecks()
+	})
+	decks := map[string]*Deck{}
+	for name, deck := range defaultDecks.decks {
+		decks[name] = deck.Clone()
+	}
+	return decks
+}
+
+func loadDefaultDecks() map[string]*Deck {
+	decks := map[string]*Deck{}
+	files, err := deckFiles.ReadDir("decks")
+	if err != nil {
+		Log.Error("could not read the default decks", "error", err)
+		return decks
+	}
+	for _, file := range files {
+		deck, err := loadDeckFile("decks/" + file.Name())
+		if err != nil {
+			Log.Error("could not load a default deck", "file", file.Name(), "error", err)
+			continue
+		}
+		decks[deck.Name] = deck
+	}
+	return decks
+}
+
+func loadDeckFile(path string) (*Deck, error) {
+	data, err := deckFiles.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var d Deck
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+	return NewDeck(d.Name, d.Cards)
+}
+
+// Clone copies a deck and what has been drawn from it. Cards aren't changed
+// once a deck is made, so the copy shares them.
+func (d *Deck) Clone() *Deck {
+	drawn := map[int]bool{}
+	for idx := range d.drawn {
+		drawn[idx] = true
+	}
+	return &Deck{Name: d.Name, Cards: d.Cards, drawn: drawn}
+}
+
+// Reset puts every drawn card back, called when a new game starts.
+func (d *Deck) Reset() {
+	d.drawn = map[int]bool{}
+}
+
+// Draw takes a random undrawn card of the given level out of the deck.
+// Once every card of a level has been drawn that level is reshuffled.
+func (d *Deck) Draw(level int) (Card, bool) {
+	candidates := []int{}
+	for idx, card := range d.Cards {
+		if level == DECK_ANY_LEVEL || card.Level == level {
+			candidates = append(candidates, idx)
+		}
+	}
+	if len(candidates) == 0 {
+		return Card{}, false
+	}
+
+	undrawn := []int{}
+	for _, idx := range candidates {
+		if !d.drawn[idx] {
+			undrawn = append(undrawn, idx)
+		}
+	}
+	if len(undrawn) == 0 {
+		for _, idx := range candidates {
+			delete(d.drawn, idx)
+		}
+		undrawn = candidates
+	}
+
+	idx := undrawn[rand.Intn(len(undrawn))]
+	d.drawn[idx] = true
+	return d.Cards[idx], true
+}
+
+func (d *Deck) Info() DeckInfo {
+	levels := map[int]bool{}
+	for _, card := range d.Cards {
+		levels[card.Level] = true
+	}
+	info := DeckInfo{Name: d.Name, Levels: []int{}, Size: len(d.Cards), Remaining: len(d.Cards) - len(d.drawn)}
+	for level := range levels {
+		info.Levels = append(info.Levels, level)
+	}
+	sort.Ints(info.Levels)
+	return info
+}
+
+func (d *Deck) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Info())
+}
+
+func (r *Room) ResetDecks() {
+	for _, deck := range r.Decks {
+		deck.Reset()
+	}
+}
+
+// DrawCard returns the text of a card for a rule that references a deck,
+// or an empty string if the rule has no deck or the deck can't supply one.
+func (r *Room) DrawCard(rule Rule, v int) string {
+	if rule.Deck == "" {
+		return ""
+	}
+	deck, ok := r.Decks[rule.Deck]
+	if !ok {
+		return ""
+	}
+	level := rule.DeckLevel
+	switch level {
+	case DECK_VALUE_LEVEL:
+		level = v
+	case DECK_ROLL_LEVEL:
+		level = rand.Intn(6) + 1
+	}
+	card, ok := deck.Draw(level)
+	if !ok {
+		return ""
+	}
+	return card.Text
+}
@@ -1,17 +1,26 @@
 package main
 
 import (
+	"encoding/json"
 	"math/rand"
-	"github.com/gorilla/websocket"
 	"errors"
 	"sync"
 	"fmt"
+	"strings"
+	"time"
+	"regexp"
 )
 
 const (
 	DICE_SIZE = 6
 )
 
+var (
+	ErrGameEnded = errors.New("game has ended")
+	ErrWaitingForPlayers = errors.New("waiting for more players")
+	ErrWrongPlayer = errors.New("wrong player")
+)
+
 type Hole struct {
 	X float64 `json:"x"`
 	Y float64 `json:"y"`
@@ -27,16 +36,29 @@ type GameBoard struct {
 	CurrentPlayer int `json:"current_player"`
 	RoundsRepeated int `json:"rounds_repeated"`
 	Finished bool `json:"finished"`
+	Turn int `json:"turn"`
+	Captures int `json:"captures"`
+	Teams []int `json:"teams"` // team of each player, nil when not playing in teams
 }
 
 type Event struct {
 	Owngoal int `json:"owngoal"`
 	Eaten int `json:"eaten"`
 	Repeat int `json:"repeat"`
-	Collected int `json:"repeat"`
+	Collected int `json:"collected"`
 	EndOfRound int `json:"end_of_round"`
 	Index int `json:"index"`
 	Victory int `json:"victory"` // 1 - won, 2 - tied, negative - lost
+	PassedStore int `json:"passed_store"` // number of opponent stores sown through
+	Lap int `json:"lap"` // number of full laps of the board sown
+	EmptyLanding int `json:"empty_landing"` // last stone landed in an empty opponent hole
+	EmptiedSide int `json:"emptied_side"`
+	TookLead int `json:"took_lead"` // lead over the next best player
+	FellBehind int `json:"fell_behind"` // distance behind the leader
+	FirstCapture int `json:"first_capture"` // number of stones in the capture
+	TurnStart int `json:"turn_start"` // turn number
+	Bomb int `json:"bomb"` // number of bomb stones captured
+	SeriesVictory int `json:"series_victory"` // 1 - won, 2 - tied, negative - games behind the winner
 	Player int `json:"player"`
 	Stones []int `json:"stones"`
 }
@@ -51,8 +73,36 @@ type Rule struct {
 	Min int `json:"min"`
 	Max int `json:"max"`
 	CycleValueOnDie bool `json:"cycle_value_on_die"`
+	Deck string `json:"deck"`
+	DeckLevel int `json:"deck_level"` // -1 - any level, -2 - level of the rule value, -3 - a rolled level
+	StoneLabel string `json:"stone_label"`
+	Drink bool `json:"drink"` // prompt is an alcoholic drink, subject to the room's safety settings
+}
+
+// UnmarshalJSON defaults a rule's deck level to any level, since level 0 is
+// a level cards can have. Rules that don't say whether they are a drink, like
+// those written before the flag existed, are one if they draw no card and
+// their text mentions drinking.
+func (r *Rule) UnmarshalJSON(data []byte) error {
+	type rule Rule
+	v := struct {
+		rule
+		Drink *bool `json:"drink"`
+	}{rule: rule{DeckLevel: DECK_ANY_LEVEL}}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*r = Rule(v.rule)
+	if v.Drink != nil {
+		r.Drink = *v.Drink
+	} else {
+		r.Drink = r.Deck == "" && drinkText.MatchString(r.Text)
+	}
+	return nil
 }
 
+var drinkText = regexp.MustCompile(`(?i)\b(drinks?|shots?|sips?)\b`)
+
 type Action struct {
 	Code string `json:"code"`
 	Player string `json:"player"`
@@ -62,7 +112,10 @@ type Action struct {
 
 type Player struct {
 	Name string `json:"name"`
-	Conns map[*websocket.Conn]bool `json:"-"`
+	OptOut bool `json:"opt_out"` // player's drinks are always substituted
+	Profile bool `json:"profile"` // results are recorded to the player's profile
+	Conns map[Conn]bool `json:"-"`
+	Chats []time.Time `json:"-"` // when the player last sent chat, for rate limiting
 }
 
 type Room struct {
@@ -70,26 +123,104 @@ type Room struct {
 	Code string `json:"code"`
 	Players []*Player `json:"players"`
 	Board *GameBoard `json:"board"`
-	History []string `json:"history"`
+	History []HistoryEntry `json:"-"`
+	HistoryDropped int `json:"-"` // entries trimmed from the start of History
 	Rules []Rule `json:"rules"`
 	SPMode bool `json:"sp_mode"`
+	Decks map[string]*Deck `json:"decks"`
+	Stones []StoneType `json:"stones"`
+	Safety SafetySettings `json:"safety"`
+	Drinks []DrinkRecord `json:"-"`
+	GameDrinks map[string]int `json:"-"`
+	Party PartySettings `json:"party"`
+	Points map[string]int `json:"points"` // penalty points from party mode
+	TeamsMode bool `json:"teams_mode"`
+	GameNumber int `json:"game_number"`
+	Results []GameResult `json:"results"`
+	Series *Series `json:"series"`
+	Tournament string `json:"tournament"` // code of the tournament this room is a match of
+	Match int `json:"match"`
+	Public bool `json:"public"` // listed in the room browser
+	Messages []ChatMessage `json:"-"`
+	ChatCount int `json:"-"`
+	Touched time.Time `json:"-"` // last time anything happened in the room
 }
 
 func NewRoom(code string, size int, sp_mode bool) (*Room, error) {
-	var board *GameBoard
-	if size == 2 {
-		board = NewTwoPlayerBoard()
-	} else {
-		return nil, errors.New("Invalid board size")
+	board, err := NewBoard(size)
+	if err != nil {
+		return nil, err
 	}
-	return &Room{
+	room := &Room{
 		Code: code,
 		Board: board,
-		Rules: NewDefaultRules(),
+		Rules: config.NewRules(),
 		Players: []*Player{},
-		History: []string{"Game started!"},
+		History: []HistoryEntry{},
 		SPMode: sp_mode,
-	}, nil
+		Decks: LoadDefaultDecks(),
+		Stones: NewDefaultStones(),
+		Safety: NewDefaultSafety(),
+		GameDrinks: map[string]int{},
+		Party: NewDefaultParty(),
+		Points: map[string]int{},
+		GameNumber: 1,
+		Results: []GameResult{},
+		Messages: []ChatMessage{},
+		Touched: time.Now(),
+	}
+	room.AddHistory(NewHistoryMessage("Game started!"))
+	return room, nil
+}
+
+// MarshalJSON is the room state sent to players, with derived fields
+// computed at the time it is sent.
+func (r *Room) MarshalJSON() ([]byte, error) {
+	type Alias Room
+	return json.Marshal(struct {
+		*Alias
+		RoomDerived
+	}{(*Alias)(r), RoomDerived{r.RecentHistory(HISTORY_STATE_ENTRIES), r.HistoryLen(), r.HistoryDropped, r.Pacing(), r.Timeline(TIMELINE_STATE_ENTRIES)}})
+}
+
+// RoomDerived are the fields MarshalJSON adds to a room.
+type RoomDerived struct {
+	History []string `json:"history"`
+	HistoryLen int `json:"history_len"` // entries ever added, the history is paged from HistoryFirst
+	HistoryFirst int `json:"history_first"`
+	Pacing []Pacing `json:"pacing"`
+	Timeline []TimelineEntry `json:"timeline"`
+}
+
+// NewGame replaces a finished game with a fresh board for the same players,
+// keeping the room's settings and history. Within a series seats are kept and
+// the first player rotates, otherwise seats are shuffled.
+func (r *Room) NewGame() error {
+	board, err := NewBoard(r.Board.NumPlayers)
+	if err != nil {
+		return err
+	}
+	if err := board.SetTeams(r.TeamsMode); err != nil {
+		return err
+	}
+	if r.Series != nil && r.Series.Finished {
+		r.Series = nil
+	}
+
+	r.GameNumber += 1
+	msg := "Game reset!"
+	if r.Series != nil {
+		board.CurrentPlayer = (r.Series.FirstPlayer + r.GameNumber - r.Series.FirstGame) % board.NumPlayers
+		msg = fmt.Sprintf("Game %d of %d started!", r.Series.Played+1, r.Series.BestOf)
+	} else {
+		rand.Shuffle(len(r.Players), func(i, j int) { r.Players[i], r.Players[j] = r.Players[j], r.Players[i] })
+	}
+	r.Board = board
+	r.AddHistory(NewHistoryMessage(msg))
+	r.ResetDecks()
+	r.ResetGameDrinks()
+	r.ResetPoints()
+	return nil
 }
 
 func (r *Room) GetPlayer(name string) (*Player, int) {
@@ -122,6 +253,40 @@ func (g *GameBoard) PlayerWinholeIdx(pidx int) int {
 	return -1
 }
 
+// Scores returns the number of stones in each player's winhole.
+func (g *GameBoard) Scores() []int {
+	scores := make([]int, g.NumPlayers)
+	for _, hole := range g.Holes {
+		if hole.Winhole {
+			scores[hole.Player] += len(hole.Stones)
+		}
+	}
+	return scores
+}
+
+// Leader returns the player with the most stones in their winhole and their
+// lead over the next best player, or -1 if the lead is shared.
+func Leader(scores []int) (int, int) {
+	leader := -1
+	best := 0
+	second := 0
+	for idx, score := range scores {
+		if leader < 0 || score > best {
+			if leader >= 0 {
+				second = best
+			}
+			leader = idx
+			best = score
+		} else if score > second {
+			second = score
+		}
+	}
+	if len(scores) > 1 && best == second {
+		return -1, 0
+	}
+	return leader, best - second
+}
+
 func ShuffledStoneProvider(num int, chunk int) func()([]int) {
 	stones := make([]int, num)
 	for i := 0; i < num; i++ {
@@ -203,10 +368,12 @@ func NewDefaultRules() []Rule {
 				Eaten: 1,
 				Collected: 1,
 				EndOfRound: 1,
-				Stones: []int{0},
 			},
+			StoneLabel: "confession",
 			TriggerOnOpponent: true,
 			Text: "give a level 6 confession",
+			Deck: "confessions",
+			DeckLevel: 6,
 		},
 		Rule{
 			Event: Event{
@@ -216,6 +383,7 @@ func NewDefaultRules() []Rule {
 			},
 			TriggerOnOpponent: true,
 			ScaleWithNum: true,
+			Drink: true,
 			Text: "take a drink!",
 		},
 		Rule{
@@ -223,6 +391,8 @@ func NewDefaultRules() []Rule {
 				Repeat: 2,
 			},
 			Text: "best/worst category",
+			Deck: "categories",
+			DeckLevel: DECK_ANY_LEVEL,
 			Max: 2,
 			Min: 2,
 		},
@@ -231,6 +401,8 @@ func NewDefaultRules() []Rule {
 				Owngoal: 1,
 			},
 			Text: "give a dice roll confession",
+			Deck: "confessions",
+			DeckLevel: DECK_ROLL_LEVEL,
 		},
 		Rule{
 			Event: Event{
@@ -256,8 +428,18 @@ func NewDefaultRules() []Rule {
 			},
 			TriggerOnVictim: true,
 			Text: "receive a dice roll truth",
+			Deck: "truths",
+			DeckLevel: DECK_ROLL_LEVEL,
 			Min: 3,
 		},
+		Rule{
+			Event: Event{
+				Bomb: 1,
+			},
+			ScaleWithNum: true,
+			Drink: true,
+			Text: "take a drink!",
+		},
 		Rule{
 			Event: Event{
 				Victory: 1,
@@ -266,60 +448,62 @@ func NewDefaultRules() []Rule {
 			EmbedValue: true,
 			Max: -1,
 			CycleValueOnDie: true,
+			Deck: "confessions",
+			DeckLevel: DECK_VALUE_LEVEL,
 		},
 	}
 	return rules
 }
 
-func (r *Room) Combine(ev Event, rule Rule, v int) []string {
-	s := ""
+func (r *Room) PlayerName(idx int) string {
+	if idx >= 0 && idx < len(r.Players) {
+		return r.Players[idx].Name
+	}
+	return fmt.Sprintf("Player %d", idx+1)
+}
+
+// Targets returns the players a rule's prompt is addressed to for an event.
+func (r *Room) Targets(ev Event, rule Rule) []int {
 	if rule.TriggerOnOpponent {
-		for i := 0; i < r.Board.NumPlayers; i++ {
-			if i == ev.Player {
-				continue
-			}
-			name := fmt.Sprintf("Player %d", i+1)
-			if i < len(r.Players) {
-				name = r.Players[i].Name
-			}
-			s += name + ","
-		}
-		s = s[:len(s)-1] + ": "
+		return r.Board.Opponents(ev.Player)
 	} else if rule.TriggerOnVictim {
 		index := r.Board.Holes[ev.Index].OpposingHoleIdx
 		if index < 0 {
 			index = ev.Index
 		}
-		owner := r.Board.Holes[index].Player
-		name := fmt.Sprintf("Player %d", owner+1)
-		if owner < len(r.Players) {
-			name = r.Players[owner].Name
-		}
-		s = name + ": "
-	} else {
-		name := fmt.Sprintf("Player %d", ev.Player+1)
-		if ev.Player < len(r.Players) {
-			name = r.Players[ev.Player].Name
-		}
-		s = name + ": "
+		return []int{r.Board.Holes[index].Player}
 	}
-	s += rule.Text
+	return []int{ev.Player}
+}
+
+func (r *Room) Combine(targets []int, rule Rule, v int) []string {
+	names := []string{}
+	for _, target := range targets {
+		names = append(names, r.PlayerName(target))
+	}
+	text := rule.Text
 	if rule.EmbedValue {
-		s = fmt.Sprintf(s, v)
+		text = fmt.Sprintf(EscapeFormatVerbs(text), v)
 	}
+	s := strings.Join(names, ",") + ": " + text
+	n := 1
 	if rule.ScaleWithNum && v > 1 {
-		out := make([]string, v)
-		for i := 0; i < v; i++ {
-			out[i] = s
+		n = v
+	}
+	out := make([]string, n)
+	for i := 0; i < n; i++ {
+		out[i] = s
+		if card := r.DrawCard(rule, v); card != "" {
+			out[i] += " - " + card
 		}
-		return out
-	} else {
-		return []string{s}
 	}
+	return out
 }
 
-func (r *Room) ApplyGenericRule(ev Event, rule Rule, f func(Event)int) ([]string, bool) {
-	ret := []string{}
+// MatchRule checks a rule against one value of an event and returns the
+// value the rule fires with.
+func (r *Room) MatchRule(ev Event, rule Rule, f func(Event)int) (int, bool) {
+	ret := 0
 
 	valev := f(ev)
 	valru := f(rule.Event)
@@ -350,6 +534,9 @@ func (r *Room) ApplyGenericRule(ev Event, rule Rule, f func(Event)int) ([]string
 			}
 		}
 	}
+	if rule.StoneLabel != "" && !r.HasLabelledStone(ev.Stones, rule.StoneLabel) {
+		return ret, false
+	}
 	if valev < 0 {
 		valev = valev * -1
 	}
@@ -359,94 +546,71 @@ func (r *Room) ApplyGenericRule(ev Event, rule Rule, f func(Event)int) ([]string
 			valev = 6
 		}
 	}
-	return r.Combine(ev, rule, valev), true
+	return valev, true
 }
 
-func (r *Room) ApplyRules(ev Event) []string {
-	logs := []string{}
-
-	for _, rule := range r.Rules {
-		// Do all the generic rules
-		nlogs, found := r.ApplyGenericRule(ev, rule, func(ev Event)int{
-			return ev.Owngoal
-		})
-		logs = append(logs, nlogs...)
-		if found {
-			continue
-		}
-
-		nlogs, found = r.ApplyGenericRule(ev, rule, func(ev Event)int{
-			return ev.Eaten
-		})
-		logs = append(logs, nlogs...)
-		if found {
-			continue
-		}
-
-		nlogs, found = r.ApplyGenericRule(ev, rule, func(ev Event)int{
-			return ev.Repeat
-		})
-		logs = append(logs, nlogs...)
-		if found {
-			continue
-		}
-		
-		nlogs, found = r.ApplyGenericRule(ev, rule, func(ev Event)int{
-			return ev.Collected
-		})
-		logs = append(logs, nlogs...)
-		if found {
-			continue
-		}
+// EventValues are the event fields a rule can trigger on, checked in order.
+// A rule fires on the first one it matches.
+var EventValues = []func(Event)int{
+	func(ev Event)int{ return ev.Owngoal },
+	func(ev Event)int{ return ev.Eaten },
+	func(ev Event)int{ return ev.Repeat },
+	func(ev Event)int{ return ev.Collected },
+	func(ev Event)int{ return ev.EndOfRound },
+	func(ev Event)int{ return ev.Victory },
+	func(ev Event)int{ return ev.PassedStore },
+	func(ev Event)int{ return ev.Lap },
+	func(ev Event)int{ return ev.EmptyLanding },
+	func(ev Event)int{ return ev.EmptiedSide },
+	func(ev Event)int{ return ev.TookLead },
+	func(ev Event)int{ return ev.FellBehind },
+	func(ev Event)int{ return ev.FirstCapture },
+	func(ev Event)int{ return ev.TurnStart },
+	func(ev Event)int{ return ev.Bomb },
+	func(ev Event)int{ return ev.SeriesVictory },
+}
 
-		nlogs, found = r.ApplyGenericRule(ev, rule, func(ev Event)int{
-			return ev.EndOfRound
-		})
-		logs = append(logs, nlogs...)
-		if found {
-			continue
-		}
+func (r *Room) ApplyRules(idx int, ev Event) []Outcome {
+	outcomes := []Outcome{}
 
-		nlogs, found = r.ApplyGenericRule(ev, rule, func(ev Event)int{
-			return ev.Victory
-		})
-		logs = append(logs, nlogs...)
-		if found {
-			continue
+	for ridx, rule := range r.Rules {
+		for _, f := range EventValues {
+			v, found := r.MatchRule(ev, rule, f)
+			if found {
+				outcomes = append(outcomes, r.RuleOutcomes(idx, ridx, ev, rule, v)...)
+				break
+			}
 		}
 	}
-	return logs
+	return outcomes
 }
 
-func (r *Room) HandleEvents(evs []Event) string {
-	logs := map[string]int{}
-	for _, ev := range evs {
-		incoming := r.ApplyRules(ev)
-		for _, log := range incoming {
-			logs[log] += 1
-		}
+func (r *Room) RuleOutcomes(idx int, ridx int, ev Event, rule Rule, v int) []Outcome {
+	targets := r.Targets(ev, rule)
+	if rule.Drink {
+		return r.DrinkOutcomes(idx, ridx, targets, rule, v)
 	}
-	out := ""
-	for k, v := range logs {
-		if v > 1 {
-			out += fmt.Sprintf(k + " x%d" + "\n", v)
-		} else {
-			out += k + "\n"
-		}
+	return GroupOutcomes(idx, ridx, targets, r.Combine(targets, rule, v))
+}
+
+func (r *Room) HandleEvents(evs []Event) []Outcome {
+	outcomes := []Outcome{}
+	for idx, ev := range evs {
+		outcomes = append(outcomes, r.ApplyRules(idx, ev)...)
 	}
-	return out
+	return outcomes
 }
 
 func (r *Room) DoAction(a *Action) error {
 	if r.Board.Finished {
-		return errors.New("game has ended")
+		return ErrGameEnded
 	}
 	if len(r.Players) < r.Board.NumPlayers && !r.SPMode {
-		return errors.New("waiting for more players")
+		return ErrWaitingForPlayers
 	}
 	p, pidx := r.GetPlayer(a.Player)
 	if !r.SPMode && (p == nil || pidx != r.Board.CurrentPlayer) {
-		return errors.New("wrong player")
+		return ErrWrongPlayer
 	}
 	if a.Index < 0 || a.Index >= len(r.Board.Holes) {
 		return errors.New("specified hole does not exist")
@@ -464,12 +628,21 @@ func (r *Room) DoAction(a *Action) error {
 		return errors.New("cannot play an empty hole")
 	}
 	player := r.Board.CurrentPlayer
+	r.Board.Turn += 1
+	prevScores := r.Board.SideScores()
+	prevLeader, _ := Leader(prevScores)
 
 	// Pick up the stones
 	evs := []Event{}
+	passedStores := 0
 	stones := Shuffle(r.Board.Holes[a.Index].Stones)
 	r.Board.Holes[a.Index].Stones = []int{}
 
+	// Every full circuit of the board back to the starting hole is a lap
+	if laps := len(stones) / len(r.Board.Holes); laps > 0 {
+		evs = append(evs, Event{Lap: laps, Index: a.Index, Player: player})
+	}
+
 	// Drop each stone
 	hIdx := a.Index
 	for idx, stone := range stones {
@@ -477,6 +650,7 @@ func (r *Room) DoAction(a *Action) error {
 
 		last := idx == (len(stones) - 1)
 		repeat := last && r.Board.Holes[hIdx].Winhole && r.Board.Holes[hIdx].Player == player
+		repeat = repeat || (last && r.StoneHasEffect(stone, STONE_WILD))
 
 		if repeat {
 			r.Board.RoundsRepeated += 1
@@ -492,11 +666,16 @@ func (r *Room) DoAction(a *Action) error {
 			if r.Board.Holes[hIdx].Player == player {
 				evs = append(evs, Event{Collected: 1, Index: hIdx, Player: player, Stones: []int{stone}})
 			} else {
+				passedStores += 1
 				evs = append(evs, Event{Owngoal: 1, Index: hIdx, Player: player, Stones: []int{stone}})
 				evs = append(evs, Event{Collected: 1, Index: hIdx, Player: r.Board.Holes[hIdx].Player, Stones: []int{stone}})
 			}
 		}
 
+		if last && !r.Board.Holes[hIdx].Winhole && r.Board.Holes[hIdx].Player != player && len(r.Board.Holes[hIdx].Stones) == 1 {
+			evs = append(evs, Event{EmptyLanding: 1, Index: hIdx, Player: player, Stones: []int{stone}})
+		}
+
 		oppositeHoleIdx := r.Board.Holes[hIdx].OpposingHoleIdx
 		if last && len(r.Board.Holes[hIdx].Stones) == 1 && !r.Board.Holes[hIdx].Winhole && r.Board.Holes[hIdx].Player == player && len(r.Board.Holes[oppositeHoleIdx].Stones) > 0 {
 			winIdx := r.Board.PlayerWinholeIdx(player)
@@ -513,8 +692,24 @@ func (r *Room) DoAction(a *Action) error {
 			r.Board.Holes[oppositeHoleIdx].Stones = []int{}
 
 			evs = append(evs, ev)
+			bombs := []int{}
+			for _, eaten := range ev.Stones {
+				if r.StoneHasEffect(eaten, STONE_BOMB) {
+					bombs = append(bombs, eaten)
+				}
+			}
+			if len(bombs) > 0 {
+				evs = append(evs, Event{Bomb: len(bombs), Player: player, Index: hIdx, Stones: bombs})
+			}
+			if r.Board.Captures == 0 {
+				evs = append(evs, Event{FirstCapture: numEaten, Player: player, Index: hIdx, Stones: ev.Stones})
+			}
+			r.Board.Captures += 1
 		}
 	}
+	if passedStores > 0 {
+		evs = append(evs, Event{PassedStore: passedStores, Index: a.Index, Player: player})
+	}
 
 	// Check for end condition
 	nFreeStones := make([]int, r.Board.NumPlayers)
@@ -530,6 +725,39 @@ func (r *Room) DoAction(a *Action) error {
 			gameOver = true
 		}
 	}
+	if nFreeStones[player] == 0 {
+		evs = append(evs, Event{EmptiedSide: 1, Index: a.Index, Player: player})
+	}
+
+	// Check for changes in the standings, by team in teams mode
+	scores := r.Board.SideScores()
+	leader, lead := Leader(scores)
+	if leader >= 0 && leader != prevLeader {
+		for _, member := range r.Board.SideMembers(leader) {
+			evs = append(evs, Event{TookLead: lead, Player: member})
+		}
+	}
+	for side, score := range scores {
+		best := 0
+		prevBest := 0
+		for oside := range scores {
+			if oside == side {
+				continue
+			}
+			if scores[oside] > best {
+				best = scores[oside]
+			}
+			if prevScores[oside] > prevBest {
+				prevBest = prevScores[oside]
+			}
+		}
+		behind := best - score
+		if behind > 0 && behind > prevBest - prevScores[side] {
+			for _, member := range r.Board.SideMembers(side) {
+				evs = append(evs, Event{FellBehind: behind, Player: member})
+			}
+		}
+	}
 
 	// Handle game ending
 	if gameOver {
@@ -553,20 +781,20 @@ func (r *Room) DoAction(a *Action) error {
 			r.Board.Holes[pWinHoles[idx]].Stones = append(r.Board.Holes[pWinHoles[idx]].Stones, stones...)
 		}
 
+		// Teams win or lose together on their combined stores
+		totals := r.Board.SideScores()
 		maxStones := 0
 		nTied := 0
-		totalStones := make([]int, r.Board.NumPlayers)
-		for idx, _ := range totalStones {
-			total := len(r.Board.Holes[pWinHoles[idx]].Stones)
-			if  total > maxStones {
+		for idx, total := range totals {
+			if idx == 0 || total > maxStones {
 				maxStones = total
 				nTied = 0
 			} else if total == maxStones {
 				nTied++
 			}
 		}
-		for idx, _ := range totalStones {
-			total := len(r.Board.Holes[pWinHoles[idx]].Stones)
+		for idx := 0; idx < r.Board.NumPlayers; idx++ {
+			total := totals[r.Board.Side(idx)]
 			vic := 0
 			if total == maxStones && nTied == 0 {
 				vic = 1
@@ -577,13 +805,26 @@ func (r *Room) DoAction(a *Action) error {
 			}
 			evs = append(evs, Event{Victory: vic, Player: idx})
 		}
+		evs = append(evs, r.RecordResult(evs)...)
 	}
 
-	// Handle Rules
-	nhistory := r.HandleEvents(evs)
-	if len(nhistory) > 0 {
-		r.History = append(r.History, nhistory)
+	if !r.Board.Finished {
+		evs = append(evs, Event{TurnStart: r.Board.Turn + 1, Player: r.Board.CurrentPlayer})
 	}
 
+	// Handle Rules
+	entry := HistoryEntry{
+		Move: r.Board.Turn,
+		Actor: player,
+		Hole: a.Index,
+		Events: evs,
+		Outcomes: r.HandleEvents(evs),
+		Time: time.Now(),
+	}
+	if r.Board.Finished && r.Series != nil {
+		entry.Message = r.SeriesSummary()
+	}
+	r.AddHistory(entry)
+
 	return nil
 }
\ No newline at end of file
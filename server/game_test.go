@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// newTestRoom returns a full room whose holes hold the given numbers of
+// stones, numbered in order, with the first player to move.
+func newTestRoom(t *testing.T, size int, holes []int) *Room {
+	t.Helper()
+	room, err := NewRoom("TEST", size, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holes) != len(room.Board.Holes) {
+		t.Fatalf("board has %d holes, got counts for %d", len(room.Board.Holes), len(holes))
+	}
+	for p := 0; p < size; p++ {
+		room.Players = append(room.Players, &Player{Name: fmt.Sprintf("p%d", p+1)})
+	}
+	room.Board.CurrentPlayer = 0
+	room.Stones = []StoneType{}
+	stone := 0
+	for idx, hole := range room.Board.Holes {
+		hole.Stones = []int{}
+		for n := 0; n < holes[idx]; n++ {
+			hole.Stones = append(hole.Stones, stone)
+			stone++
+		}
+	}
+	return room
+}
+
+// play moves for the current player and returns the events of the move.
+func play(t *testing.T, room *Room, hole int) []Event {
+	t.Helper()
+	player := room.PlayerName(room.Board.CurrentPlayer)
+	if err := room.DoAction(&Action{Player: player, Index: hole}); err != nil {
+		t.Fatal(err)
+	}
+	return room.History[len(room.History)-1].Events
+}
+
+// eventValues are the non-zero values of one field of the events, in order.
+func eventValues(evs []Event, f func(Event) int) []int {
+	values := []int{}
+	for _, ev := range evs {
+		if v := f(ev); v != 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func TestMoveEvents(t *testing.T) {
+	lap := func(ev Event) int { return ev.Lap }
+	passed := func(ev Event) int { return ev.PassedStore }
+	landing := func(ev Event) int { return ev.EmptyLanding }
+	first := func(ev Event) int { return ev.FirstCapture }
+	lead := func(ev Event) int { return ev.TookLead }
+	behind := func(ev Event) int { return ev.FellBehind }
+
+	tests := []struct {
+		name     string
+		holes    []int
+		captures int
+		hole     int
+		field    func(Event) int
+		want     []int
+	}{
+		{"short of a lap", []int{13, 4, 4, 4, 4, 4, 0, 4, 4, 4, 4, 4, 4, 0}, 0, 0, lap, []int{}},
+		{"a lap", []int{14, 4, 4, 4, 4, 4, 0, 4, 4, 4, 4, 4, 4, 0}, 0, 0, lap, []int{1}},
+		{"two laps", []int{28, 4, 4, 4, 4, 4, 0, 4, 4, 4, 4, 4, 4, 0}, 0, 0, lap, []int{2}},
+		{"store passed on a lap", []int{14, 4, 4, 4, 4, 4, 0, 4, 4, 4, 4, 4, 4, 0}, 0, 0, passed, []int{1}},
+		{"own store is not passed", []int{4, 4, 4, 4, 4, 3, 0, 4, 4, 4, 4, 4, 4, 0}, 0, 5, passed, []int{}},
+		{"empty opponent hole", []int{4, 4, 4, 4, 4, 3, 0, 4, 0, 4, 4, 4, 4, 0}, 0, 5, landing, []int{1}},
+		{"full opponent hole", []int{4, 4, 4, 4, 4, 3, 0, 4, 4, 4, 4, 4, 4, 0}, 0, 5, landing, []int{}},
+		{"empty own hole", []int{2, 4, 0, 4, 4, 4, 0, 4, 4, 4, 4, 4, 4, 0}, 0, 0, landing, []int{}},
+		{"first capture", []int{2, 4, 0, 4, 4, 4, 0, 4, 4, 4, 4, 4, 4, 0}, 0, 0, first, []int{4}},
+		{"later capture", []int{2, 4, 0, 4, 4, 4, 0, 4, 4, 4, 4, 4, 4, 0}, 1, 0, first, []int{}},
+		{"no capture", []int{2, 4, 4, 4, 4, 4, 0, 4, 4, 4, 4, 4, 4, 0}, 0, 0, first, []int{}},
+		{"takes the lead from a tie", []int{4, 4, 4, 4, 4, 1, 0, 4, 4, 4, 4, 4, 4, 0}, 0, 5, lead, []int{1}},
+		{"extends a lead", []int{4, 4, 4, 4, 4, 1, 3, 4, 4, 4, 4, 4, 4, 0}, 0, 5, lead, []int{}},
+		{"closes on the leader", []int{4, 4, 4, 4, 4, 1, 0, 4, 4, 4, 4, 4, 4, 3}, 0, 5, lead, []int{}},
+		{"overtakes the leader", []int{4, 4, 4, 4, 4, 1, 3, 4, 4, 4, 4, 4, 4, 3}, 0, 5, lead, []int{1}},
+		{"opponent falls behind", []int{4, 4, 4, 4, 4, 1, 3, 4, 4, 4, 4, 4, 4, 0}, 0, 5, behind, []int{4}},
+		{"closing the gap", []int{4, 4, 4, 4, 4, 1, 0, 4, 4, 4, 4, 4, 4, 3}, 0, 5, behind, []int{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room := newTestRoom(t, 2, test.holes)
+			room.Board.Captures = test.captures
+			got := eventValues(play(t, room, test.hole), test.field)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
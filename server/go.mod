@@ -1,5 +1,12 @@
 module drunkala
 
-go 1.14
+go 1.23.0
 
-require github.com/gorilla/websocket v1.4.2
+require (
+	github.com/gorilla/websocket v1.4.2
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/crypto v0.35.0
+	golang.org/x/term v0.29.0
+)
+
+require golang.org/x/sys v0.30.0 // indirect
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	HISTORY_STATE_ENTRIES = 20   // Rendered entries included in each state response
+	HISTORY_PAGE_SIZE     = 20   // Default page size for the history endpoint
+	HISTORY_MAX_PAGE_SIZE = 100  // Largest page the history endpoint will return
+	HISTORY_MAX_ENTRIES   = 1000 // Entries of earlier games a room keeps
+)
+
+// Outcome is a rule firing on one event of a move. Identical prompts from the
+// same rule and event (e.g. ScaleWithNum) are grouped into a count.
+type Outcome struct {
+	Event   int    `json:"event"`   // index into the entry's events
+	Rule    int    `json:"rule"`    // index into the room's rules at the time
+	Players []int  `json:"players"` // players the prompt is addressed to
+	Text    string `json:"text"`
+	Count   int    `json:"count"`
+}
+
+type HistoryEntry struct {
+	Game     int       `json:"game"`
+	Move     int       `json:"move"`
+	Actor    int       `json:"actor"` // player index, -1 for room messages
+	Hole     int       `json:"hole"`  // hole played, -1 for room messages
+	Events   []Event   `json:"events"`
+	Outcomes []Outcome `json:"outcomes"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+func NewHistoryMessage(msg string) HistoryEntry {
+	return HistoryEntry{
+		Actor:    -1,
+		Hole:     -1,
+		Events:   []Event{},
+		Outcomes: []Outcome{},
+		Message:  msg,
+		Time:     time.Now(),
+	}
+}
+
+// Text renders the entry the way it is shown to players, one prompt per line
+// in event order.
+func (h HistoryEntry) Text() string {
+	lines := []string{}
+	if h.Message != "" {
+		lines = append(lines, h.Message)
+	}
+	for _, o := range h.Outcomes {
+		if o.Count > 1 {
+			lines = append(lines, fmt.Sprintf("%s x%d", o.Text, o.Count))
+		} else {
+			lines = append(lines, o.Text)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (h HistoryEntry) MarshalJSON() ([]byte, error) {
+	type Alias HistoryEntry
+	return json.Marshal(struct {
+		Alias
+		HistoryEntryDerived
+	}{Alias(h), HistoryEntryDerived{h.Text()}})
+}
+
+// HistoryEntryDerived are the fields MarshalJSON adds to a history entry.
+type HistoryEntryDerived struct {
+	Text string `json:"text"`
+}
+
+// GroupOutcomes turns the prompts of a rule firing into outcomes, grouping
+// consecutive identical prompts.
+func GroupOutcomes(ev int, rule int, players []int, logs []string) []Outcome {
+	out := []Outcome{}
+	for _, log := range logs {
+		if n := len(out); n > 0 && out[n-1].Text == log {
+			out[n-1].Count += 1
+			continue
+		}
+		out = append(out, Outcome{Event: ev, Rule: rule, Players: players, Text: log, Count: 1})
+	}
+	return out
+}
+
+func (r *Room) AddHistory(entry HistoryEntry) {
+	entry.Game = r.GameNumber
+	r.History = append(r.History, entry)
+	r.TrimHistory(config.Limits.MaxHistory)
+}
+
+// TrimHistory drops the oldest entries of earlier games while there are more
+// than max. The current game is kept whole since its result is worked out
+// from it. Offsets into the history count the dropped entries.
+func (r *Room) TrimHistory(max int) {
+	n := 0
+	for len(r.History)-n > max && r.History[n].Game < r.GameNumber {
+		n++
+	}
+	r.History = r.History[n:]
+	r.HistoryDropped += n
+}
+
+// HistoryLen is the number of entries ever added, including dropped ones.
+func (r *Room) HistoryLen() int {
+	return r.HistoryDropped + len(r.History)
+}
+
+// RecentHistory is the rendered text of the latest entries that have any.
+func (r *Room) RecentHistory(n int) []string {
+	out := []string{}
+	for i := len(r.History) - 1; i >= 0 && len(out) < n; i-- {
+		if text := r.History[i].Text(); text != "" {
+			out = append(out, text)
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// HistoryPage returns up to limit entries starting at offset, along with the
+// offset actually used, which is moved past any entries that were dropped.
+func (r *Room) HistoryPage(offset int, limit int) ([]HistoryEntry, int) {
+	if limit <= 0 {
+		limit = HISTORY_PAGE_SIZE
+	}
+	if limit > HISTORY_MAX_PAGE_SIZE {
+		limit = HISTORY_MAX_PAGE_SIZE
+	}
+	if offset < r.HistoryDropped {
+		offset = r.HistoryDropped
+	}
+	if offset > r.HistoryLen() {
+		offset = r.HistoryLen()
+	}
+	start := offset - r.HistoryDropped
+	end := start + limit
+	if end > len(r.History) {
+		end = len(r.History)
+	}
+	return r.History[start:end], offset
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestHistoryPaging(t *testing.T) {
+	// Games 1 and 2 have 4 entries each and game 3 has 3
+	newRoom := func(t *testing.T, max int) *Room {
+		room, err := NewRoom("TEST", 2, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		room.History = []HistoryEntry{}
+		for game := 1; game <= 3; game++ {
+			room.GameNumber = game
+			for n := 0; n < 4 && !(game == 3 && n == 3); n++ {
+				room.History = append(room.History, HistoryEntry{Game: game, Move: n})
+			}
+		}
+		room.TrimHistory(max)
+		return room
+	}
+
+	tests := []struct {
+		name      string
+		max       int
+		offset    int
+		limit     int
+		dropped   int
+		used      int
+		entries   int
+		firstGame int
+	}{
+		{"under the cap", 20, 0, 20, 0, 0, 11, 1},
+		{"earlier game dropped", 7, 0, 20, 4, 4, 7, 2},
+		{"partly dropped game", 5, 0, 20, 6, 6, 5, 2},
+		{"current game kept whole", 1, 0, 20, 8, 8, 3, 3},
+		{"offset after the dropped entries", 7, 6, 20, 4, 6, 5, 2},
+		{"offset past the end", 7, 30, 20, 4, 11, 0, 0},
+		{"limited page", 20, 2, 3, 0, 2, 3, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room := newRoom(t, test.max)
+			if room.HistoryDropped != test.dropped {
+				t.Errorf("dropped %d entries, want %d", room.HistoryDropped, test.dropped)
+			}
+			if room.HistoryLen() != 11 {
+				t.Errorf("history length %d, want 11", room.HistoryLen())
+			}
+			entries, used := room.HistoryPage(test.offset, test.limit)
+			if used != test.used || len(entries) != test.entries {
+				t.Fatalf("page at %d with %d entries, want %d with %d", used, len(entries), test.used, test.entries)
+			}
+			if len(entries) > 0 && entries[0].Game != test.firstGame {
+				t.Errorf("page starts in game %d, want %d", entries[0].Game, test.firstGame)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func ParseLevel(name string) (Level, error) {
+	for level, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %s", name)
+}
+
+// LogSettings are shared by every logger. Change them before the server
+// starts handling requests.
+type LogSettings struct {
+	sync.Mutex
+	Level     Level
+	JSON      bool // one JSON object per line instead of key=value pairs
+	AccessLog bool // log every API request, not just failed ones
+	Output    io.Writer
+}
+
+var logSettings = &LogSettings{Level: LevelInfo, Output: os.Stderr}
+
+// Logger writes leveled messages with a set of key value fields.
+type Logger struct {
+	fields []interface{}
+}
+
+// Log is the server-wide logger, with no fields.
+var Log = &Logger{}
+
+// With returns a logger that adds key value pairs to every message.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := append(append([]interface{}{}, l.fields...), kv...)
+	return &Logger{fields: fields}
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.write(LevelDebug, msg, kv) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.write(LevelInfo, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.write(LevelWarn, msg, kv) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.write(LevelError, msg, kv) }
+
+// Fatal logs an error and exits. Only for failures while starting up.
+func (l *Logger) Fatal(msg string, kv ...interface{}) {
+	l.write(LevelError, msg, kv)
+	os.Exit(1)
+}
+
+func (l *Logger) write(level Level, msg string, kv []interface{}) {
+	logSettings.Lock()
+	defer logSettings.Unlock()
+	if level < logSettings.Level {
+		return
+	}
+
+	fields := append(append([]interface{}{}, l.fields...), kv...)
+	keys := []string{}
+	values := map[string]interface{}{}
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		var value interface{} = "MISSING"
+		if i+1 < len(fields) {
+			value = fields[i+1]
+		}
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if logSettings.JSON {
+		entry := map[string]interface{}{"time": now, "level": levelNames[level], "msg": msg}
+		for _, key := range keys {
+			entry[key] = values[key]
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			data = []byte(fmt.Sprintf(`{"time":%q,"level":"error","msg":"could not encode log entry: %s"}`, now, err))
+		}
+		logSettings.Output.Write(append(data, '\n'))
+		return
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "time=%s level=%s msg=%s", now, levelNames[level], strconv.Quote(msg))
+	for _, key := range keys {
+		value := fmt.Sprint(values[key])
+		if value == "" || strings.ContainsAny(value, " =\"\n\t") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&sb, " %s=%s", key, value)
+	}
+	sb.WriteByte('\n')
+	io.WriteString(logSettings.Output, sb.String())
+}
+
+// requestInfo is what is known about a request for logging. Handlers fill in
+// the room and player once they have read them from the request.
+type requestInfo struct {
+	sync.Mutex
+	id      string
+	handler string
+	room    string
+	player  string
+}
+
+type requestInfoKey struct{}
+
+func withRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+}
+
+// Annotate records the room and player a request is for, so they are
+// included in everything logged about it.
+func Annotate(r *http.Request, room string, player string) {
+	info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	if !ok {
+		return
+	}
+	info.Lock()
+	defer info.Unlock()
+	info.room = NormalizeCode(room)
+	info.player = player
+}
+
+// RequestLogger returns a logger with the request id, handler, room and
+// player of a request.
+func RequestLogger(r *http.Request) *Logger {
+	info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	if !ok {
+		return Log
+	}
+	info.Lock()
+	defer info.Unlock()
+	l := Log.With("request_id", info.id, "handler", info.handler)
+	if info.room != "" {
+		l = l.With("room", info.room)
+	}
+	if info.player != "" {
+		l = l.With("player", info.player)
+	}
+	return l
+}
+
+// RequestId reuses the id a proxy gave the request, like Heroku's router
+// does, or makes a new one.
+func RequestId(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); id != "" && len(id) <= 64 {
+		return id
+	}
+	return RandStringRunes(16)
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
-	"log"
 	"math/rand"
 	"net/http"
 	"encoding/json"
 	"os"
+	"flag"
+	"os/signal"
+	"syscall"
 	"github.com/gorilla/websocket"
 	"sync"
 	"time"
@@ -23,19 +25,22 @@ func RandStringRunes(n int) string {
 }
 
 func setupHeaders(w *http.ResponseWriter, req *http.Request) bool {
-	if nocors := os.Getenv("NOCORS"); nocors != "" {
-		(*w).Header().Set("Access-Control-Allow-Origin", "*")
+	if !corsHeaders(*w, req) {
+		return false
 	}
-	(*w).Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-	(*w).Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Authorization")
-	(*w).Header().Set("Content-Type", "application/json")
+	(*w).Header().Set("Access-Control-Allow-Methods", CORS_METHODS)
 
-	if req.Method != http.MethodPost && req.Method != http.MethodGet {
-		(*w).WriteHeader(http.StatusOK)
+	switch req.Method {
+	case http.MethodGet, http.MethodPost:
+		return true
+	case http.MethodOptions:
+		preflight(*w)
+		return false
+	default:
+		methodNotAllowed(*w, req, CORS_METHODS)
 		return false
 	}
-	return true
- }
+}
 
  type JSONError struct {
 	Error string `json:"error"`
@@ -47,9 +52,23 @@ func WriteError(w http.ResponseWriter ,err string, statusCode int) {
 }
 
 func (r *Room) NotifyPlayers() {
+	r.Touched = time.Now()
+	r.Broadcast(Update{})
+}
+
+// Conn is a connection a room's messages are sent to, a websocket or an SSH
+// session.
+type Conn interface {
+	WriteJSON(v interface{}) error
+	Close() error
+}
+
+// Broadcast sends a message to every connection in the room. Must be called
+// with the room locked.
+func (r *Room) Broadcast(msg interface{}) {
 	for _, player := range r.Players {
 		for ws, _ := range player.Conns {
-			err := ws.WriteJSON(struct{}{})
+			err := ws.WriteJSON(msg)
 			if err != nil {
 				ws.Close()
 				delete(player.Conns, ws)
@@ -61,9 +80,64 @@ func (r *Room) NotifyPlayers() {
 type LockedRooms struct {
 	sync.RWMutex
 	Rooms map[string]*Room
+	Closed bool // no new rooms are created while the server shuts down
+}
+
+// UniqueCode finds a room code that isn't in use. Must be called with the lock held.
+func (l *LockedRooms) UniqueCode(words bool) (string, bool) {
+	for i := 0; i < 10000; i++ {
+		code := RandomRoomCode(words)
+		if _, ok := l.Rooms[code]; !ok {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// Expire closes rooms nobody has played in or been connected to for ttl.
+// Tournament matches are kept however long they wait for their players, as
+// the tournament can't go on without them. Rooms are checked without the
+// lock held since a room's lock is taken before this one when a tournament
+// match finishes.
+func (l *LockedRooms) Expire(ttl time.Duration) int {
+	l.RLock()
+	all := map[string]*Room{}
+	for code, room := range l.Rooms {
+		all[code] = room
+	}
+	l.RUnlock()
+
+	cutoff := time.Now().Add(-ttl)
+	idle := []string{}
+	for code, room := range all {
+		room.RLock()
+		unused := room.Touched.Before(cutoff) && room.Tournament == ""
+		for _, player := range room.Players {
+			unused = unused && len(player.Conns) == 0
+		}
+		room.RUnlock()
+		if unused {
+			idle = append(idle, code)
+		}
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	for _, code := range idle {
+		delete(l.Rooms, code)
+	}
+	return len(idle)
 }
 
-func HandleCreate(rooms *LockedRooms) func(http.ResponseWriter, *http.Request) {
+// Get looks up a room by a code as a player typed it.
+func (l *LockedRooms) Get(code string) (*Room, bool) {
+	l.RLock()
+	defer l.RUnlock()
+	room, ok := l.Rooms[NormalizeCode(code)]
+	return room, ok
+}
+
+func HandleCreate(api *API) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !setupHeaders(&w, r) {
 			return
@@ -72,390 +146,705 @@ func HandleCreate(rooms *LockedRooms) func(http.ResponseWriter, *http.Request) {
 		type CreateReq struct {
 			Size int
 			Hotseat bool
+			Teams bool
+			Public bool
+			WordCode bool
 		}
 		var createReq CreateReq
 		err := json.NewDecoder(r.Body).Decode(&createReq)
 		if err != nil {
-		WriteError(w, err.Error(), http.StatusBadRequest)
+			WriteError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		rooms.Lock()
-		defer rooms.Unlock()
+		res, err := api.CreateRoom(CreateRoomRequest(createReq))
+		WriteResult(w, http.StatusCreated, res, err)
+	}
+}
 
-		type CreateRes struct {
-			Code string `json:"code"`
+func HandleState(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
+			return
 		}
 		
-		for i := 0; i < 10000; i++ {
-			code := &CreateRes{Code: RandStringRunes(6)}
+		type StateReq struct {
+			Code string
+		}
+		var stateReq StateReq
+		err := json.NewDecoder(r.Body).Decode(&stateReq)
+		if err != nil {
+			WriteError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-			if _, ok := rooms.Rooms[code.Code]; ok {
-				continue
-			}
+		Annotate(r, stateReq.Code, "")
+		state, err := api.GetRoom(stateReq.Code)
+		WriteResult(w, http.StatusOK, state, err)
+	}
+}
 
-			nr, err := NewRoom(code.Code, createReq.Size, createReq.Hotseat)
-			if err != nil {
-				WriteError(w, err.Error(), http.StatusBadRequest)
-			}
+func HandlePing(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
+			return
+		}
 
-			rooms.Rooms[code.Code] = nr
-			w.WriteHeader(http.StatusCreated)
-			json.NewEncoder(w).Encode(code)
+		type PingReq struct {
+			Code string
+			Name string
+		}
+		var req PingReq
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			WriteError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		WriteError(w, "could not create unique room code", http.StatusInternalServerError)
-		return
+		Annotate(r, req.Code, req.Name)
+		WriteResult(w, http.StatusOK, nil, api.Ping(req.Code, PingRequest{req.Name}))
 	}
 }
 
-func HandleState(rooms *LockedRooms) func(http.ResponseWriter, *http.Request) {
+func HandleJoin(api *API) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !setupHeaders(&w, r) {
 			return
 		}
-		
-		type StateReq struct {
+
+		type JoinReq struct {
 			Code string
+			Name string
+			Profile bool
+			ProfileToken string
 		}
-		var stateReq StateReq
-		err := json.NewDecoder(r.Body).Decode(&stateReq)
+		var joinReq JoinReq
+		err := json.NewDecoder(r.Body).Decode(&joinReq)
 		if err != nil {
 			WriteError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if stateReq.Code == "" {
-			WriteError(w, "lobby code missing from join request", http.StatusBadRequest)
+
+		Annotate(r, joinReq.Code, joinReq.Name)
+		state, _, err := api.JoinRoom(joinReq.Code, JoinRequest{joinReq.Name, joinReq.Profile, joinReq.ProfileToken})
+		WriteResult(w, http.StatusCreated, state, err)
+	}
+}
+
+// HandleAction makes a move, or starts a new game if the game has finished
+// and the action asks for a reset.
+func HandleAction(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
 			return
 		}
 
-		rooms.Lock()
-		room, ok := rooms.Rooms[stateReq.Code]
-		rooms.Unlock()
+		var input Action
+		err := json.NewDecoder(r.Body).Decode(&input)
+		if err != nil {
+			WriteError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		Annotate(r, input.Code, input.Player)
+		move := MoveRequest{input.Player, input.Index}
+		if input.Reset {
+			err = api.Reset(input.Code)
+			if err == ErrGameNotFinished {
+				err = api.Move(input.Code, move, RequestLogger(r))
+			}
+		} else {
+			err = api.Move(input.Code, move, RequestLogger(r))
+		}
+		WriteResult(w, http.StatusCreated, nil, err)
+	}
+}
 
-		if !ok {
-			WriteError(w, "tried to get board state for nonexistant lobby", http.StatusBadRequest)
+func HandleStream(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		codes, ok := r.URL.Query()["code"]
+		if !ok || len(codes) == 0{
+			WriteError(w, "did not have room code in request", http.StatusBadRequest)
 			return
 		}
+		code := codes[0]
 
-		room.Lock()
-		defer room.Unlock()
+		names, ok := r.URL.Query()["name"]
+		if !ok || len(names) == 0 {
+			WriteError(w, "did not have player name in request", http.StatusBadRequest)
+			return
+		}
+		name := names[0]
 
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(room)
-		return
+		Annotate(r, code, name)
+		api.Stream(w, r, code, name)
 	}
 }
 
-func HandlePing(rooms *LockedRooms) func(http.ResponseWriter, *http.Request) {
+// Update tells a room's players it changed, so they fetch its state again.
+type Update struct{}
+
+type StreamMessage struct {
+	Chat     string `json:"chat"`
+	Reaction string `json:"reaction"`
+}
+
+type ChatEvent struct {
+	Chat ChatMessage `json:"chat"`
+}
+
+func HandleChat(api *API) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !setupHeaders(&w, r) {
 			return
 		}
 
-		type PingReq struct {
+		type ChatReq struct {
 			Code string
 			Name string
+			Text string
+			Reaction string
 		}
-		var req PingReq
+		var req ChatReq
 		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
 			WriteError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if req.Code == "" {
-			WriteError(w, "lobby code missing from ping request", http.StatusBadRequest)
+
+		Annotate(r, req.Code, req.Name)
+		if req.Text != "" || req.Reaction != "" {
+			if _, err := api.Chat(req.Code, ChatRequest{req.Name, req.Text, req.Reaction}); err != nil {
+				WriteResult(w, 0, nil, err)
+				return
+			}
+		}
+		res, err := api.Messages(req.Code)
+		WriteResult(w, http.StatusOK, res, err)
+	}
+}
+
+func HandleRule(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
 			return
 		}
 
-		if req.Name == "" {
-			WriteError(w, "name missing from ping request", http.StatusBadRequest)
+		type RuleReq struct {
+			Code string
+			Delete bool
+			Id int
+			Rule Rule
+		}
+		var req RuleReq
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			WriteError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		rooms.Lock()
-		room, ok := rooms.Rooms[req.Code]
-		rooms.Unlock()
+		Annotate(r, req.Code, "")
+		if req.Delete {
+			err = api.DeleteRule(req.Code, req.Id)
+		} else {
+			_, err = api.AddRule(req.Code, req.Rule)
+		}
+		WriteResult(w, http.StatusOK, nil, err)
+	}
+}
 
-		if !ok {
-			WriteError(w, "no such lobby", http.StatusBadRequest)
+func HandleDeck(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
 			return
 		}
 
-		room.Lock()
-		defer room.Unlock()
+		type DeckReq struct {
+			Code string
+			Delete bool
+			Name string
+			Cards []Card
+		}
+		var req DeckReq
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			WriteError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		type Ping struct {
-			Ping string `json:"ping"`
+		Annotate(r, req.Code, "")
+		if req.Delete {
+			err = api.DeleteDeck(req.Code, req.Name)
+		} else {
+			err = api.PutDeck(req.Code, req.Name, req.Cards)
 		}
+		WriteResult(w, http.StatusOK, nil, err)
+	}
+}
 
-		for idx, player := range room.Players {
-			if req.Name != player.Name && idx == room.Board.CurrentPlayer {
-				for ws, _ := range player.Conns {
-					nerr := ws.WriteJSON(Ping{req.Name})
-					if nerr != nil {
-						err = nerr
-					}
-				}
-			}
+func HandleStones(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
+			return
 		}
 
+		type StonesReq struct {
+			Code string
+			Stones []StoneType
+		}
+		var req StonesReq
+		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
 			WriteError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		
-		w.WriteHeader(http.StatusOK)
+
+		Annotate(r, req.Code, "")
+		WriteResult(w, http.StatusOK, nil, api.SetStones(req.Code, req.Stones))
 	}
 }
 
-func HandleJoin(rooms *LockedRooms) func(http.ResponseWriter, *http.Request) {
+func HandleHistory(api *API) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !setupHeaders(&w, r) {
 			return
 		}
 
-		type JoinReq struct {
+		type HistoryReq struct {
 			Code string
-			Name string
+			Offset int
+			Limit int
 		}
-		var joinReq JoinReq
-		err := json.NewDecoder(r.Body).Decode(&joinReq)
+		var req HistoryReq
+		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
 			WriteError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if joinReq.Code == "" || joinReq.Name == "" {
-			WriteError(w, "name or lobby code missing from join request", http.StatusBadRequest)
+
+		Annotate(r, req.Code, "")
+		res, err := api.History(req.Code, req.Offset, req.Limit)
+		WriteResult(w, http.StatusOK, res, err)
+	}
+}
+
+func HandleSimulate(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
 			return
 		}
 
-		rooms.Lock()
-		room, ok := rooms.Rooms[joinReq.Code]
-		rooms.Unlock()
+		type SimulateReq struct {
+			Code string
+			Size int
+			Teams bool
+			Games int
+			Rules []Rule
+		}
+		var req SimulateReq
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			WriteError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		Annotate(r, req.Code, "")
+		report, err := api.Simulate(r.Context(), SimulateRequest(req))
+		WriteResult(w, http.StatusOK, report, err)
+	}
+}
 
-		if !ok {
-			WriteError(w, "tried to join nonexistant lobby", http.StatusBadRequest)
+func HandleSafety(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
 			return
 		}
 
-		room.Lock()
-		defer room.Unlock()
+		type SafetyReq struct {
+			Code string
+			Safety *SafetySettings
+			Name string
+			OptOut *bool
+		}
+		var req SafetyReq
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			WriteError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		for _, player := range room.Players {
-			if player.Name == joinReq.Name {
-				w.WriteHeader(http.StatusCreated)
-				json.NewEncoder(w).Encode(room)
-				return
-			}
+		Annotate(r, req.Code, req.Name)
+		if req.Safety != nil {
+			err = api.SetSafety(req.Code, *req.Safety)
 		}
+		if err == nil && req.OptOut != nil {
+			err = api.SetOptOut(req.Code, req.Name, *req.OptOut)
+		}
+		WriteResult(w, http.StatusOK, nil, err)
+	}
+}
 
-		newPlayer := &Player{Name: joinReq.Name, Conns: map[*websocket.Conn]bool{}}
-		room.Players = append(room.Players, newPlayer)
+func HandleParty(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
+			return
+		}
 
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(room)
-		room.NotifyPlayers()
+		type PartyReq struct {
+			Code string
+			Enabled *bool
+			Alternative *Alternative
+			Name string
+			Override *Alternative
+			ClearOverride bool
+		}
+		var req PartyReq
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			WriteError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		return
+		Annotate(r, req.Code, req.Name)
+		err = api.SetParty(req.Code, PartyRequest{req.Enabled, req.Alternative})
+		if err == nil && req.ClearOverride {
+			err = api.SetOverride(req.Code, req.Name, nil)
+		} else if err == nil && req.Override != nil {
+			err = api.SetOverride(req.Code, req.Name, req.Override)
+		}
+		WriteResult(w, http.StatusOK, nil, err)
 	}
 }
 
-func HandleAction(rooms *LockedRooms) func(http.ResponseWriter, *http.Request) {
+func HandleSeries(api *API) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !setupHeaders(&w, r) {
 			return
 		}
 
-		var input Action
-		err := json.NewDecoder(r.Body).Decode(&input)
+		type SeriesReq struct {
+			Code string
+			BestOf int
+			Cancel bool
+		}
+		var req SeriesReq
+		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
 			WriteError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if input.Code == "" {
-			WriteError(w, "lobby code missing from input", http.StatusBadRequest)
+
+		Annotate(r, req.Code, "")
+		if req.Cancel {
+			err = api.CancelSeries(req.Code)
+		} else {
+			err = api.StartSeries(req.Code, req.BestOf)
+		}
+		WriteResult(w, http.StatusOK, nil, err)
+	}
+}
+
+func HandleLeaderboard(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
 			return
 		}
-		if input.Player == "" {
-			WriteError(w, "name missing from input", http.StatusBadRequest)
+
+		n := 0
+		if limits, ok := r.URL.Query()["limit"]; ok && len(limits) > 0 {
+			fmt.Sscanf(limits[0], "%d", &n)
+		}
+
+		WriteResult(w, http.StatusOK, api.Leaderboard(n), nil)
+	}
+}
+
+func HandleProfile(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
 			return
 		}
 
-		rooms.Lock()
-		room, ok := rooms.Rooms[input.Code]
-		rooms.Unlock()
+		names, ok := r.URL.Query()["name"]
+		if !ok || len(names) == 0 {
+			WriteError(w, "did not have player name in request", http.StatusBadRequest)
+			return
+		}
 
-		if !ok {
-			WriteError(w, "no such lobby", http.StatusBadRequest)
+		profile, err := api.Profile(names[0])
+		WriteResult(w, http.StatusOK, profile, err)
+	}
+}
+
+func HandleProfileCreate(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
 			return
 		}
 
-		room.Lock()
-		defer room.Unlock()
+		var req ProfileRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			WriteError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		if room.Board.Finished && input.Reset {
-			newRoom, err := NewRoom(room.Code, room.Board.NumPlayers, room.SPMode)
-			if err != nil {
-				WriteError(w, "error in creating new game", http.StatusBadRequest)
-				return
-			}
-			room.Board = newRoom.Board
-			rand.Shuffle(len(room.Players), func(i, j int) { room.Players[i], room.Players[j] = room.Players[j], room.Players[i] })
-			room.History = []string{"Game reset!"}
-		} else {
-			err = room.DoAction(&input)
+		Annotate(r, "", req.Name)
+		res, err := api.CreateProfile(req)
+		WriteResult(w, http.StatusCreated, res, err)
+	}
+}
+
+func HandleTournamentCreate(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
+			return
 		}
 
-		if err == nil {
-			room.NotifyPlayers()
+		type TournamentReq struct {
+			Format string
+			Participants []string
 		}
+		var req TournamentReq
+		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
 			WriteError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		w.WriteHeader(http.StatusCreated)
+
+		res, err := api.CreateTournament(TournamentRequest(req))
+		WriteResult(w, http.StatusCreated, res, err)
 	}
 }
 
-func HandleStream(rooms *LockedRooms, upgrader *websocket.Upgrader) func(http.ResponseWriter, *http.Request) {
+func HandleTournamentState(api *API) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		codes, ok := r.URL.Query()["code"]
-		if !ok || len(codes) == 0{
-			WriteError(w, "did not have room code in request", http.StatusBadRequest)
+		if !setupHeaders(&w, r) {
 			return
 		}
-		code := codes[0]
 
-		names, ok := r.URL.Query()["name"]
-		if !ok || len(names) == 0 {
-			WriteError(w, "did not have player name in request", http.StatusBadRequest)
+		type TournamentStateReq struct {
+			Code string
+		}
+		var req TournamentStateReq
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			WriteError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		name := names[0]
 
-		rooms.Lock()
-		room, ok := rooms.Rooms[code]
-		rooms.Unlock()
+		state, err := api.GetTournament(req.Code)
+		WriteResult(w, http.StatusOK, state, err)
+	}
+}
 
-		if !ok {
-			WriteError(w, "tried to start stream for nonexistant lobby", http.StatusBadRequest)
+func HandleTournamentStream(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		codes, ok := r.URL.Query()["code"]
+		if !ok || len(codes) == 0 {
+			WriteError(w, "did not have tournament code in request", http.StatusBadRequest)
 			return
 		}
 
-		room.Lock()
-		defer room.Unlock()
+		api.TournamentStream(w, r, codes[0])
+	}
+}
 
-		type Heartbeat struct {
-			Heartbeat bool `json:"heartbeat"`
+func HandleRooms(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
+			return
 		}
 
-		for _, player := range room.Players {
-			if player.Name == name {
-				ws, err := upgrader.Upgrade(w, r, nil)
-				if err != nil {
-					log.Fatalln(err.Error())
-				}
-				player.Conns[ws] = true
-
-				go func() {
-					ticker := time.NewTicker(500 * time.Millisecond)
-					for {
-						select {
-						case  <-ticker.C:
-							hb := Heartbeat{}
-							errws := ws.WriteJSON(hb)
-							if errws != nil {
-								return
-							}
-						}
-					}
-				}()
-				
-				return
-			}
+		size := 0
+		if sizes, ok := r.URL.Query()["size"]; ok && len(sizes) > 0 {
+			fmt.Sscanf(sizes[0], "%d", &size)
 		}
-		WriteError(w, "tried to start stream for nonexistant player", http.StatusBadRequest)
+
+		WriteResult(w, http.StatusOK, api.ListRooms(size), nil)
 	}
 }
 
-func HandleRule(rooms *LockedRooms) func(http.ResponseWriter, *http.Request) {
+func HandleQueue(api *API) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !setupHeaders(&w, r) {
 			return
 		}
 
-		type RuleReq struct {
-			Code string
-			Delete bool
-			Id int
-			Rule Rule
+		type QueueReq struct {
+			Name string
+			Size int
+			Teams bool
+			Ticket string
+			Cancel bool
 		}
-		var req RuleReq
+		var req QueueReq
 		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
 			WriteError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if req.Code == "" {
-			WriteError(w, "lobby code missing from prompt request", http.StatusBadRequest)
+
+		// With a ticket this is a poll or a cancel, otherwise joining the queue
+		if req.Ticket != "" && req.Cancel {
+			WriteResult(w, http.StatusOK, nil, api.CancelTicket(req.Ticket))
+			return
+		}
+		if req.Ticket != "" {
+			ticket, err := api.PollTicket(req.Ticket)
+			WriteResult(w, http.StatusOK, ticket, err)
 			return
 		}
 
-		rooms.Lock()
-		room, ok := rooms.Rooms[req.Code]
-		rooms.Unlock()
+		ticket, err := api.Enqueue(QueueRequest{req.Name, req.Size, req.Teams})
+		WriteResult(w, http.StatusCreated, ticket, err)
+	}
+}
 
-		if !ok {
-			WriteError(w, "no such lobby", http.StatusBadRequest)
+func HandleInvite(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
 			return
 		}
 
-		room.Lock()
-		defer room.Unlock()
+		codes, ok := r.URL.Query()["code"]
+		if !ok || len(codes) == 0 {
+			WriteError(w, "did not have room code in request", http.StatusBadRequest)
+			return
+		}
+		Annotate(r, codes[0], "")
+		res, err := api.Invite(r, codes[0], "/api/qr?code=" + NormalizeCode(codes[0]) + "&")
+		WriteResult(w, http.StatusOK, res, err)
+	}
+}
 
-		if req.Delete {
-			if req.Id < len(room.Rules) && req.Id > 0 {
-				room.Rules = append(room.Rules[:req.Id], room.Rules[req.Id+1:]...)
-			} else {
-				WriteError(w, "invalid rule id", http.StatusBadRequest)
-				return
-			}
-		} else {
-			room.Rules = append(room.Rules, req.Rule)
+func HandleQR(api *API) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !setupHeaders(&w, r) {
+			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		room.NotifyPlayers()
+		codes, ok := r.URL.Query()["code"]
+		if !ok || len(codes) == 0 {
+			WriteError(w, "did not have room code in request", http.StatusBadRequest)
+			return
+		}
+		Annotate(r, codes[0], "")
+		img, contentType, err := api.QR(r, codes[0], r.URL.Query().Get("format"))
+		WriteImage(w, img, contentType, err)
 	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		if err := RunSimulateCommand(os.Args[2:]); err != nil {
+			Log.Fatal("simulation failed", "error", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "play" {
+		if err := RunClientCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "openapi" {
+		if err := RunOpenAPICommand(os.Args[2:]); err != nil {
+			Log.Fatal("writing the api document failed", "error", err)
+		}
+		return
+	}
+
 	rand.Seed(time.Now().UnixNano())
-	host := "0.0.0.0"
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "4000"
+	cfg, err := LoadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		Log.Fatal("invalid config", "error", err)
+	}
+	if err := cfg.Apply(); err != nil {
+		Log.Fatal("invalid config", "error", err)
 	}
 
 	rooms := &LockedRooms{Rooms: make(map[string]*Room)}
-
-	checkOrigin := func(r *http.Request)bool{ 
-		{ return true }
+	tournaments := &LockedTournaments{Tournaments: make(map[string]*Tournament)}
+	matchmaker := NewMatchmaker()
+	limits := NewRateLimits(cfg.RateLimits)
+	profiles, err := NewProfileStore(cfg.Profiles)
+	if err != nil {
+		Log.Fatal("could not load profiles", "error", err)
+	}
+	go profiles.RunSaver(PROFILE_SAVE_DELAY)
+	if cfg.RoomTTL.Duration > 0 {
+		go func() {
+			for range time.Tick(time.Minute) {
+				if n := rooms.Expire(cfg.RoomTTL.Duration); n > 0 {
+					Log.Info("closed idle rooms", "rooms", n)
+				}
+			}
+		}()
 	}
+
 	upgrader := &websocket.Upgrader{
-		CheckOrigin: checkOrigin,
-	}
-
-	http.HandleFunc("/api/create", HandleCreate(rooms))
-	http.HandleFunc("/api/join", HandleJoin(rooms))
-	http.HandleFunc("/api/input", HandleAction(rooms))
-	http.HandleFunc("/api/state", HandleState(rooms))
-	http.HandleFunc("/api/stream", HandleStream(rooms, upgrader))
-	http.HandleFunc("/api/ping", HandlePing(rooms))
-	http.HandleFunc("/api/rule", HandleRule(rooms))
-	http.Handle("/", http.FileServer(http.Dir("/home/apps/drunkala/client/build")))
-	log.Println("Game server starting on", host, port)
-	log.Println(http.ListenAndServe(fmt.Sprintf("%s:%s", host, port), nil))
+		CheckOrigin: CheckOrigin,
+	}
+	api := &API{rooms, profiles, tournaments, matchmaker, upgrader}
+	sshListener, err := ServeSSH(cfg, api, limits)
+	if err != nil {
+		Log.Fatal("could not serve ssh", "error", err)
+	}
+
+	http.HandleFunc("/api/create", Instrument("create", limits.Wrap("create", HandleCreate(api))))
+	http.HandleFunc("/api/join", Instrument("join", limits.Wrap("join", HandleJoin(api))))
+	http.HandleFunc("/api/input", Instrument("input", limits.Wrap("input", HandleAction(api))))
+	http.HandleFunc("/api/state", Instrument("state", HandleState(api)))
+	http.HandleFunc("/api/stream", Instrument("stream", HandleStream(api)))
+	http.HandleFunc("/api/ping", Instrument("ping", limits.Wrap("ping", HandlePing(api))))
+	http.HandleFunc("/api/chat", Instrument("chat", limits.Wrap("chat", HandleChat(api))))
+	http.HandleFunc("/api/rule", Instrument("rule", limits.Wrap("rule", HandleRule(api))))
+	http.HandleFunc("/api/deck", Instrument("deck", limits.Wrap("deck", HandleDeck(api))))
+	http.HandleFunc("/api/stones", Instrument("stones", limits.Wrap("stones", HandleStones(api))))
+	http.HandleFunc("/api/history", Instrument("history", HandleHistory(api)))
+	http.HandleFunc("/api/simulate", Instrument("simulate", limits.Wrap("simulate", HandleSimulate(api))))
+	http.HandleFunc("/api/safety", Instrument("safety", limits.Wrap("safety", HandleSafety(api))))
+	http.HandleFunc("/api/party", Instrument("party", limits.Wrap("party", HandleParty(api))))
+	http.HandleFunc("/api/series", Instrument("series", limits.Wrap("series", HandleSeries(api))))
+	http.HandleFunc("/api/leaderboard", Instrument("leaderboard", HandleLeaderboard(api)))
+	http.HandleFunc("/api/profile", Instrument("profile", HandleProfile(api)))
+	http.HandleFunc("/api/profile/create", Instrument("profile/create", limits.Wrap("profile/create", HandleProfileCreate(api))))
+	http.HandleFunc("/api/rooms", Instrument("rooms", HandleRooms(api)))
+	http.HandleFunc("/api/invite", Instrument("invite", HandleInvite(api)))
+	http.HandleFunc("/api/qr", Instrument("qr", HandleQR(api)))
+	http.HandleFunc("/api/queue", Instrument("queue", limits.Wrap("queue", HandleQueue(api))))
+	http.HandleFunc("/api/tournament/create", Instrument("tournament/create", limits.Wrap("tournament/create", HandleTournamentCreate(api))))
+	http.HandleFunc("/api/tournament/state", Instrument("tournament/state", HandleTournamentState(api)))
+	http.HandleFunc("/api/tournament/stream", Instrument("tournament/stream", HandleTournamentStream(api)))
+	http.Handle(API_V1_PREFIX + "/", NewV1Router(api, limits))
+	http.HandleFunc("/metrics", HandleMetrics(rooms, tournaments))
+	http.HandleFunc("/healthz", HandleHealth())
+	http.HandleFunc("/readyz", HandleReady(rooms))
+	http.Handle("/", http.FileServer(http.Dir(cfg.StaticDir)))
+
+	srv := &http.Server{Addr: cfg.Listen}
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		sig := <-signals
+		Log.Info("shutting down", "signal", sig.String())
+		if sshListener != nil {
+			sshListener.Close()
+		}
+		Shutdown(srv, rooms, tournaments, profiles)
+		close(stopped)
+	}()
+
+	Log.Info("game server starting", "listen", cfg.Listen)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		Log.Fatal("game server failed", "error", err)
+	}
+	<-stopped
+	Log.Info("game server stopped")
 }
\ No newline at end of file
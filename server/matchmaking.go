@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	STATUS_WAITING  = "waiting"
+	STATUS_PLAYING  = "playing"
+	STATUS_FINISHED = "finished"
+
+	TICKET_TTL = 10 * time.Minute // Unmatched queue tickets are dropped after this long without a poll
+)
+
+// RoomListing is what the public room browser shows about a room.
+type RoomListing struct {
+	Code      string `json:"code"`
+	Size      int    `json:"size"`
+	Teams     bool   `json:"teams"`
+	Players   int    `json:"players"`
+	SeatsOpen int    `json:"seats_open"`
+	Status    string `json:"status"`
+}
+
+func (r *Room) Status() string {
+	if r.Board.Finished {
+		return STATUS_FINISHED
+	}
+	if len(r.Players) < r.Board.NumPlayers && !r.SPMode {
+		return STATUS_WAITING
+	}
+	return STATUS_PLAYING
+}
+
+func (r *Room) Listing() RoomListing {
+	open := r.Board.NumPlayers - len(r.Players)
+	if open < 0 || r.SPMode {
+		open = 0
+	}
+	return RoomListing{
+		Code:      r.Code,
+		Size:      r.Board.NumPlayers,
+		Teams:     r.TeamsMode,
+		Players:   len(r.Players),
+		SeatsOpen: open,
+		Status:    r.Status(),
+	}
+}
+
+// PublicRooms lists the public rooms, those with open seats first.
+func (l *LockedRooms) PublicRooms(size int) []RoomListing {
+	l.RLock()
+	rooms := []*Room{}
+	for _, room := range l.Rooms {
+		rooms = append(rooms, room)
+	}
+	l.RUnlock()
+
+	listings := []RoomListing{}
+	for _, room := range rooms {
+		room.RLock()
+		if room.Public && (size == 0 || room.Board.NumPlayers == size) {
+			listings = append(listings, room.Listing())
+		}
+		room.RUnlock()
+	}
+	sort.Slice(listings, func(i, j int) bool {
+		if listings[i].SeatsOpen != listings[j].SeatsOpen {
+			return listings[i].SeatsOpen > listings[j].SeatsOpen
+		}
+		return listings[i].Code < listings[j].Code
+	})
+	return listings
+}
+
+type Ticket struct {
+	Id      string    `json:"id"`
+	Name    string    `json:"name"`
+	Size    int       `json:"size"`
+	Teams   bool      `json:"teams"`
+	Code    string    `json:"code"` // room the player was matched into, empty while waiting
+	Created time.Time `json:"created"`
+	Polled  time.Time `json:"-"`
+}
+
+// Matchmaker pairs waiting players into new rooms by variant and player count.
+type Matchmaker struct {
+	sync.Mutex
+	Tickets map[string]*Ticket
+	Queue   []*Ticket
+}
+
+func NewMatchmaker() *Matchmaker {
+	return &Matchmaker{Tickets: map[string]*Ticket{}, Queue: []*Ticket{}}
+}
+
+// expire drops tickets nobody has polled for a while. Must be called with the lock held.
+func (m *Matchmaker) expire() {
+	cutoff := time.Now().Add(-TICKET_TTL)
+	queue := []*Ticket{}
+	for _, ticket := range m.Queue {
+		if ticket.Polled.Before(cutoff) {
+			delete(m.Tickets, ticket.Id)
+			continue
+		}
+		queue = append(queue, ticket)
+	}
+	m.Queue = queue
+	for id, ticket := range m.Tickets {
+		if ticket.Code != "" && ticket.Polled.Before(cutoff) {
+			delete(m.Tickets, id)
+		}
+	}
+}
+
+// Enqueue adds a player to the queue and seats them in a new room as soon as
+// enough players are waiting for the same variant.
+func (m *Matchmaker) Enqueue(rooms *LockedRooms, name string, size int, teams bool) (*Ticket, error) {
+	name, err := CleanName(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := NewBoard(size); err != nil {
+		return nil, err
+	}
+	if teams && size < MIN_TEAMS_PLAYERS {
+		return nil, errors.New("teams need a board with at least 4 players")
+	}
+
+	rooms.RLock()
+	closed := rooms.Closed
+	rooms.RUnlock()
+	if closed {
+		return nil, ErrShuttingDown
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.expire()
+
+	for _, waiting := range m.Queue {
+		if waiting.Name == name {
+			return nil, errors.New("already waiting in the queue")
+		}
+	}
+
+	id := ""
+	for i := 0; i < 10000 && id == ""; i++ {
+		id = RandStringRunes(16)
+		if _, ok := m.Tickets[id]; ok {
+			id = ""
+		}
+	}
+	if id == "" {
+		return nil, errors.New("could not create unique ticket")
+	}
+
+	now := time.Now()
+	ticket := &Ticket{Id: id, Name: name, Size: size, Teams: teams, Created: now, Polled: now}
+	m.Tickets[id] = ticket
+	m.Queue = append(m.Queue, ticket)
+
+	matched := []*Ticket{}
+	for _, waiting := range m.Queue {
+		if waiting.Size == size && waiting.Teams == teams {
+			matched = append(matched, waiting)
+		}
+		if len(matched) == size {
+			break
+		}
+	}
+	if len(matched) < size {
+		return ticket, nil
+	}
+
+	rooms.Lock()
+	defer rooms.Unlock()
+
+	if rooms.Closed {
+		return nil, ErrShuttingDown
+	}
+	if len(rooms.Rooms) >= config.Limits.MaxRooms {
+		return nil, ErrServerFull
+	}
+	code, ok := rooms.UniqueCode(false)
+	if !ok {
+		return nil, errors.New("could not create unique room code")
+	}
+	room, err := NewRoom(code, size, false)
+	if err == nil {
+		err = room.SetTeams(teams)
+	}
+	if err != nil {
+		return nil, err
+	}
+	inRoom := map[*Ticket]bool{}
+	for _, t := range matched {
+		room.Players = append(room.Players, &Player{Name: t.Name, Conns: map[Conn]bool{}})
+		t.Code = code
+		inRoom[t] = true
+	}
+	room.AddHistory(NewHistoryMessage(fmt.Sprintf("Matched %d players from the queue!", size)))
+	rooms.Rooms[code] = room
+
+	queue := []*Ticket{}
+	for _, waiting := range m.Queue {
+		if !inRoom[waiting] {
+			queue = append(queue, waiting)
+		}
+	}
+	m.Queue = queue
+	return ticket, nil
+}
+
+// Poll returns the current state of a ticket, keeping it alive.
+func (m *Matchmaker) Poll(id string) (Ticket, bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.expire()
+
+	ticket, ok := m.Tickets[id]
+	if !ok {
+		return Ticket{}, false
+	}
+	ticket.Polled = time.Now()
+	return *ticket, true
+}
+
+func (m *Matchmaker) Cancel(id string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	ticket, ok := m.Tickets[id]
+	if !ok || ticket.Code != "" {
+		return false
+	}
+	delete(m.Tickets, id)
+	queue := []*Ticket{}
+	for _, waiting := range m.Queue {
+		if waiting.Id != id {
+			queue = append(queue, waiting)
+		}
+	}
+	m.Queue = queue
+	return true
+}
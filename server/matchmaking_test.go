@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMatchmaker(t *testing.T) {
+	type step struct {
+		cancel bool // cancel the player's ticket rather than queue them
+		name   string
+		size   int
+		teams  bool
+		ok     bool
+	}
+	join := func(name string, size int, teams bool) step { return step{false, name, size, teams, true} }
+	tests := []struct {
+		name    string
+		steps   []step
+		rooms   []string // players seated together, comma separated
+		waiting []string
+	}{
+		{"pair", []step{join("a", 2, false), join("b", 2, false)}, []string{"a,b"}, []string{}},
+		{"sizes wait apart", []step{join("a", 2, false), join("b", 4, false)}, []string{}, []string{"a", "b"}},
+		{"first come first served", []step{join("a", 2, false), join("b", 4, false), join("c", 2, false), join("d", 2, false)},
+			[]string{"a,c"}, []string{"b", "d"}},
+		{"teams wait apart", []step{join("a", 4, true), join("b", 4, false), join("c", 4, true), join("d", 4, true), join("e", 4, true)},
+			[]string{"a,c,d,e"}, []string{"b"}},
+		{"name already waiting", []step{join("a", 2, false), {false, "a", 4, false, false}}, []string{}, []string{"a"}},
+		{"bad size", []step{{false, "a", 3, false, false}}, []string{}, []string{}},
+		{"teams of two", []step{{false, "a", 2, true, false}}, []string{}, []string{}},
+		{"cancel while waiting", []step{join("a", 2, false), {true, "a", 0, false, true}, join("b", 2, false)},
+			[]string{}, []string{"b"}},
+		{"cancel after pairing", []step{join("a", 2, false), join("b", 2, false), {true, "a", 0, false, false}},
+			[]string{"a,b"}, []string{}},
+		{"cancel twice", []step{join("a", 2, false), {true, "a", 0, false, true}, {true, "a", 0, false, false}},
+			[]string{}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rooms := &LockedRooms{Rooms: map[string]*Room{}}
+			m := NewMatchmaker()
+			tickets := map[string]string{}
+			for i, s := range test.steps {
+				if s.cancel {
+					if ok := m.Cancel(tickets[s.name]); ok != s.ok {
+						t.Errorf("step %d: cancelling %s got %v, want %v", i, s.name, ok, s.ok)
+					}
+					continue
+				}
+				ticket, err := m.Enqueue(rooms, s.name, s.size, s.teams)
+				if (err == nil) != s.ok {
+					t.Fatalf("step %d: queueing %s got error %v, want ok %v", i, s.name, err, s.ok)
+				}
+				if err == nil {
+					tickets[s.name] = ticket.Id
+				}
+			}
+
+			seated := []string{}
+			for code, room := range rooms.Rooms {
+				names := []string{}
+				for _, player := range room.Players {
+					names = append(names, player.Name)
+					if ticket, ok := m.Poll(tickets[player.Name]); !ok || ticket.Code != code {
+						t.Errorf("%s's ticket %+v doesn't point at room %s", player.Name, ticket, code)
+					}
+				}
+				seated = append(seated, strings.Join(names, ","))
+			}
+			sort.Strings(seated)
+			if !reflect.DeepEqual(seated, test.rooms) {
+				t.Errorf("rooms %v, want %v", seated, test.rooms)
+			}
+
+			waiting := []string{}
+			for _, ticket := range m.Queue {
+				waiting = append(waiting, ticket.Name)
+				if polled, ok := m.Poll(ticket.Id); !ok || polled.Code != "" {
+					t.Errorf("waiting ticket %+v polled as %+v", ticket, polled)
+				}
+			}
+			if !reflect.DeepEqual(waiting, test.waiting) {
+				t.Errorf("waiting %v, want %v", waiting, test.waiting)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics are exposed at /metrics in the Prometheus text format. The format
+// is simple enough that the few metric types used here are implemented
+// directly rather than pulling in the Prometheus client.
+
+// DefaultLatencyBuckets are upper bounds in seconds for handler latency.
+var DefaultLatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+func escapeLabel(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return strings.Replace(value, "\n", `\n`, -1)
+}
+
+// labelString renders label pairs as {a="1",b="2"}, or nothing without labels.
+func labelString(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, escapeLabel(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// CounterVec is a counter for each combination of label values.
+type CounterVec struct {
+	sync.Mutex
+	Name   string
+	Help   string
+	Labels []string
+	values map[string]float64
+	labels map[string][]string
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{Name: name, Help: help, Labels: labels, values: map[string]float64{}, labels: map[string][]string{}}
+}
+
+func (c *CounterVec) Add(n float64, values ...string) {
+	if len(values) != len(c.Labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d", c.Name, len(c.Labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	c.Lock()
+	defer c.Unlock()
+	c.values[key] += n
+	c.labels[key] = values
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) Write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.Name, c.Help, c.Name)
+	keys := []string{}
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.Name, labelString(c.Labels, c.labels[key]), formatFloat(c.values[key]))
+	}
+}
+
+type histogram struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec is a histogram for each combination of label values.
+type HistogramVec struct {
+	sync.Mutex
+	Name    string
+	Help    string
+	Labels  []string
+	Buckets []float64
+	series  map[string]*histogram
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{Name: name, Help: help, Labels: labels, Buckets: buckets, series: map[string]*histogram{}}
+}
+
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	if len(values) != len(h.Labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d", h.Name, len(h.Labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	h.Lock()
+	defer h.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{labels: values, counts: make([]uint64, len(h.Buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.Buckets {
+		if v <= bound {
+			s.counts[i] += 1
+			break
+		}
+	}
+	s.count += 1
+	s.sum += v
+}
+
+func (h *HistogramVec) Write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.Name, h.Help, h.Name)
+	keys := []string{}
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	names := append(append([]string{}, h.Labels...), "le")
+	for _, key := range keys {
+		s := h.series[key]
+		cumulative := uint64(0)
+		for i, bound := range h.Buckets {
+			cumulative += s.counts[i]
+			values := append(append([]string{}, s.labels...), formatFloat(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.Name, labelString(names, values), cumulative)
+		}
+		values := append(append([]string{}, s.labels...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.Name, labelString(names, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.Name, labelString(h.Labels, s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.Name, labelString(h.Labels, s.labels), s.count)
+	}
+}
+
+func writeGauge(w io.Writer, name string, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(v))
+}
+
+type Metrics struct {
+	Actions     *CounterVec
+	RuleFirings *CounterVec
+	Errors      *CounterVec
+	Latency     *HistogramVec
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Actions:     NewCounterVec("drunkala_actions_total", "Moves and resets played.", "kind"),
+		RuleFirings: NewCounterVec("drunkala_rule_firings_total", "Rule prompts given to players.", "drink"),
+		Errors:      NewCounterVec("drunkala_errors_total", "Requests answered with an error status.", "handler", "code"),
+		Latency:     NewHistogramVec("drunkala_request_duration_seconds", "Time taken to handle API requests.", DefaultLatencyBuckets, "handler"),
+	}
+}
+
+var metrics = NewMetrics()
+
+// RecordOutcomes counts the rule firings of a move.
+func (m *Metrics) RecordOutcomes(room *Room, outcomes []Outcome) {
+	for _, o := range outcomes {
+		drink := o.Rule >= 0 && o.Rule < len(room.Rules) && room.Rules[o.Rule].Drink
+		m.RuleFirings.Add(float64(o.Count), strconv.FormatBool(drink))
+	}
+}
+
+// statusRecorder remembers the status code written by a handler, and the
+// body of an error response so it can be logged.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(data []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	if s.status >= 400 && len(s.body) < 1024 {
+		s.body = append(s.body, data...)
+	}
+	return s.ResponseWriter.Write(data)
+}
+
+// Hijack lets websocket upgrades through the recorder.
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer can not be hijacked")
+	}
+	s.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// Instrument records the latency and errors of an API handler, and logs
+// failed requests, or every request with the access log on.
+func Instrument(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{id: RequestId(r), handler: name}
+		r = withRequestInfo(r, info)
+		w.Header().Set("X-Request-Id", info.id)
+		rec := &statusRecorder{ResponseWriter: w}
+		handler(rec, r)
+		elapsed := time.Since(start)
+		metrics.Latency.Observe(elapsed.Seconds(), name)
+		if rec.status >= 400 {
+			metrics.Errors.Inc(name, strconv.Itoa(rec.status))
+		}
+
+		l := RequestLogger(r).With("method", r.Method, "status", rec.status, "duration_ms", elapsed.Milliseconds())
+		var jerr JSONError
+		if json.Unmarshal(rec.body, &jerr) == nil && jerr.Error != "" {
+			l = l.With("error", jerr.Error)
+		}
+		if rec.status >= 500 {
+			l.Error("request failed")
+		} else if rec.status >= 400 {
+			l.Warn("request failed")
+		} else if logSettings.AccessLog {
+			l.Info("request")
+		}
+	}
+}
+
+func HandleMetrics(rooms *LockedRooms, tournaments *LockedTournaments) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rooms.RLock()
+		all := []*Room{}
+		for _, room := range rooms.Rooms {
+			all = append(all, room)
+		}
+		rooms.RUnlock()
+
+		players, conns := 0, 0
+		for _, room := range all {
+			room.RLock()
+			players += len(room.Players)
+			for _, player := range room.Players {
+				for conn := range player.Conns {
+					// SSH sessions are counted by their connections
+					if _, ok := conn.(*LocalConn); !ok {
+						conns += 1
+					}
+				}
+			}
+			room.RUnlock()
+		}
+
+		tournaments.RLock()
+		watched := []*Tournament{}
+		for _, t := range tournaments.Tournaments {
+			watched = append(watched, t)
+		}
+		tournaments.RUnlock()
+		for _, t := range watched {
+			t.RLock()
+			conns += len(t.Conns)
+			t.RUnlock()
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(http.StatusOK)
+		writeGauge(w, "drunkala_rooms", "Rooms currently open.", float64(len(all)))
+		writeGauge(w, "drunkala_players", "Players seated in open rooms.", float64(players))
+		writeGauge(w, "drunkala_websocket_connections", "Open websocket connections.", float64(conns))
+		writeGauge(w, "drunkala_ssh_connections", "Open SSH connections.", float64(atomic.LoadInt64(&sshConns)))
+		writeGauge(w, "drunkala_goroutines", "Goroutines currently running.", float64(runtime.NumGoroutine()))
+		metrics.Actions.Write(w)
+		metrics.RuleFirings.Write(w)
+		metrics.Errors.Write(w)
+		metrics.Latency.Write(w)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// nopConn stands in for a websocket.
+type nopConn struct{}
+
+func (nopConn) WriteJSON(v interface{}) error { return nil }
+func (nopConn) Close() error                  { return nil }
+
+func TestMetricsConnections(t *testing.T) {
+	rooms := &LockedRooms{Rooms: map[string]*Room{}}
+	room, err := NewRoom("TEST", 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.Players = append(room.Players,
+		&Player{Name: "a", Conns: map[Conn]bool{nopConn{}: true}},
+		&Player{Name: "b", Conns: map[Conn]bool{NewLocalConn(): true}})
+	rooms.Rooms["TEST"] = room
+	atomic.AddInt64(&sshConns, 1)
+	defer atomic.AddInt64(&sshConns, -1)
+
+	w := httptest.NewRecorder()
+	HandleMetrics(rooms, &LockedTournaments{Tournaments: map[string]*Tournament{}})(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{"drunkala_players 2", "drunkala_websocket_connections 1", "drunkala_ssh_connections 1"} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("metrics have no %q:\n%s", line, w.Body.String())
+		}
+	}
+}
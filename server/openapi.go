@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const OPENAPI_VERSION = "3.0.3"
+
+// schemaOverride describes a type whose MarshalJSON doesn't encode it as its
+// own fields: either it is encoded as another type, or fields are added.
+type schemaOverride struct {
+	as    reflect.Type
+	extra reflect.Type
+}
+
+var schemaOverrides = map[reflect.Type]schemaOverride{
+	typeOf((*Room)(nil)):         {extra: typeOf((*RoomDerived)(nil))},
+	typeOf((*HistoryEntry)(nil)): {extra: typeOf((*HistoryEntryDerived)(nil))},
+	typeOf((*Tournament)(nil)):   {extra: typeOf((*TournamentDerived)(nil))},
+	typeOf((*Deck)(nil)):         {as: typeOf((*DeckInfo)(nil))},
+}
+
+// typeOf returns the type a nil pointer points to, or the type of a value.
+func typeOf(v interface{}) reflect.Type {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// schemaBuilder derives JSON schemas from Go types the way encoding/json
+// encodes them. Named structs are collected as definitions and referenced.
+type schemaBuilder struct {
+	ref  string
+	defs map[string]interface{}
+}
+
+func newSchemaBuilder(ref string) *schemaBuilder {
+	return &schemaBuilder{ref: ref, defs: map[string]interface{}{}}
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if override, ok := schemaOverrides[t]; ok && override.as != nil {
+		return b.schema(override.as)
+	}
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		if _, ok := b.defs[t.Name()]; !ok {
+			// Placeholder first so recursive types refer back to the definition
+			b.defs[t.Name()] = nil
+			b.defs[t.Name()] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": b.ref + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	b.fields(t, properties)
+	if override, ok := schemaOverrides[t]; ok && override.extra != nil {
+		b.fields(override.extra, properties)
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// fields adds the properties of a struct's fields, following json tags and
+// flattening embedded structs like encoding/json does.
+func (b *schemaBuilder) fields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				b.fields(ft, properties)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schema(field.Type)
+	}
+}
+
+// operationId names an operation after its method and path, like
+// getRoomsCodeHistory.
+func operationId(method string, pattern string) string {
+	id := strings.ToLower(method)
+	for _, segment := range splitPath(pattern) {
+		segment = strings.Trim(segment, "{}")
+		for _, word := range strings.FieldsFunc(segment, func(r rune) bool { return r == '-' || r == '.' }) {
+			id += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return id
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// OpenAPI returns the OpenAPI document of the versioned API, built from its
+// route table and the Go types of each route's request and response.
+func OpenAPI() map[string]interface{} {
+	b := newSchemaBuilder("#/components/schemas/")
+	errorSchema := b.schema(typeOf((*JSONError)(nil)))
+
+	paths := map[string]map[string]interface{}{}
+	for _, route := range V1Routes() {
+		parameters := []interface{}{}
+		for _, segment := range splitPath(route.Pattern) {
+			if strings.HasPrefix(segment, "{") {
+				name := strings.Trim(segment, "{}")
+				kind := "string"
+				if name == "id" {
+					kind = "integer"
+				}
+				parameters = append(parameters, map[string]interface{}{"name": name, "in": "path", "required": true, "schema": map[string]interface{}{"type": kind}})
+			}
+		}
+		for _, query := range route.Query {
+			parameters = append(parameters, map[string]interface{}{"name": query.Name, "in": "query", "schema": map[string]interface{}{"type": query.Type}})
+		}
+
+		success := map[string]interface{}{"description": http.StatusText(route.Status)}
+		switch {
+		case route.Response != nil:
+			success["content"] = jsonContent(b.schema(typeOf(route.Response)))
+		case route.Stream:
+			success["description"] = "Upgraded to a websocket, its messages are described by " + API_V1_PREFIX + "/stream-schema.json"
+		case len(route.ContentTypes) > 0:
+			content := map[string]interface{}{}
+			for _, contentType := range route.ContentTypes {
+				schema := map[string]interface{}{"type": "string", "format": "binary"}
+				if contentType == "application/json" {
+					schema = map[string]interface{}{"type": "object"}
+				}
+				content[contentType] = map[string]interface{}{"schema": schema}
+			}
+			success["content"] = content
+		}
+		responses := map[string]interface{}{strconv.Itoa(route.Status): success}
+		if route.AlsoStatus != 0 {
+			also := map[string]interface{}{"description": http.StatusText(route.AlsoStatus)}
+			for key, value := range success {
+				if key != "description" {
+					also[key] = value
+				}
+			}
+			responses[strconv.Itoa(route.AlsoStatus)] = also
+		}
+
+		errors := append([]int{}, route.Errors...)
+		if _, ok := config.RateLimits[route.Name]; ok && route.Method != http.MethodGet {
+			errors = append(errors, http.StatusTooManyRequests)
+		}
+		for _, status := range errors {
+			responses[strconv.Itoa(status)] = map[string]interface{}{"description": http.StatusText(status), "content": jsonContent(errorSchema)}
+		}
+
+		operation := map[string]interface{}{
+			"operationId": operationId(route.Method, route.Pattern),
+			"summary":     route.Summary,
+			"responses":   responses,
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if route.Request != nil {
+			operation["requestBody"] = map[string]interface{}{"content": jsonContent(b.schema(typeOf(route.Request)))}
+		}
+		if paths[route.Pattern] == nil {
+			paths[route.Pattern] = map[string]interface{}{}
+		}
+		paths[route.Pattern][strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": OPENAPI_VERSION,
+		"info": map[string]interface{}{
+			"title":       "drunkala",
+			"version":     "1",
+			"description": "Rooms are addressed by their code and players by name. Errors are {\"error\": \"...\"}.",
+		},
+		"servers":    []interface{}{map[string]interface{}{"url": API_V1_PREFIX}},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": b.defs},
+	}
+}
+
+type streamMessage struct {
+	Type        interface{}
+	Description string
+}
+
+// StreamSchema returns a JSON schema of the messages sent over each websocket.
+// /api/stream sends the same messages as the room stream.
+func StreamSchema() map[string]interface{} {
+	b := newSchemaBuilder("#/definitions/")
+	messages := func(list []streamMessage) map[string]interface{} {
+		schemas := []interface{}{}
+		for _, message := range list {
+			schemas = append(schemas, map[string]interface{}{
+				"description": message.Description,
+				"allOf":       []interface{}{b.schema(typeOf(message.Type))},
+			})
+		}
+		return map[string]interface{}{"anyOf": schemas}
+	}
+	restart := streamMessage{(*Restart)(nil), "the server is restarting, reconnect in a moment"}
+
+	streams := map[string]interface{}{
+		"/rooms/{code}/players/{name}/stream": map[string]interface{}{
+			"send": messages([]streamMessage{
+				{(*StreamMessage)(nil), "a chat message or a reaction"},
+			}),
+			"receive": messages([]streamMessage{
+				{(*Update)(nil), "the room changed, fetch its state"},
+				{(*Heartbeat)(nil), "keeps the connection open"},
+				{(*Ping)(nil), "another player nudged this one to play"},
+				{(*ChatEvent)(nil), "a chat message or reaction from a player in the room"},
+				{(*JSONError)(nil), "a chat message sent on this connection was refused"},
+				restart,
+			}),
+		},
+		"/tournaments/{code}/stream": map[string]interface{}{
+			"receive": messages([]streamMessage{
+				{(*Tournament)(nil), "the tournament's state, sent on connecting and after every change"},
+				restart,
+			}),
+		},
+	}
+	return map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"title":       "drunkala websocket messages",
+		"description": "Messages are JSON objects. Streams are under " + API_V1_PREFIX + ".",
+		"streams":     streams,
+		"definitions": b.defs,
+	}
+}
+
+// RunOpenAPICommand implements the "openapi" subcommand, printing the OpenAPI
+// document or the websocket schema for generating clients.
+func RunOpenAPICommand(args []string) error {
+	fs := flag.NewFlagSet("openapi", flag.ContinueOnError)
+	stream := fs.Bool("stream", false, "print the websocket message schema instead")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	doc := OpenAPI()
+	if *stream {
+		doc = StreamSchema()
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
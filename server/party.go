@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	ALT_DRINK     = "drink"     // Keep drinking, used to opt a player out of party mode
+	ALT_POINTS    = "points"    // Penalty points added to the player's tally
+	ALT_FORFEIT   = "forfeit"   // A forfeit, drawn from Deck if it is set
+	ALT_CHALLENGE = "challenge" // A mini-challenge, drawn from Deck if it is set
+)
+
+// Alternative is what a drink outcome is replaced with in party mode.
+type Alternative struct {
+	Kind   string `json:"kind"`
+	Text   string `json:"text"`
+	Deck   string `json:"deck"`
+	Points int    `json:"points"` // points per drink replaced
+}
+
+type PartySettings struct {
+	Enabled     bool                   `json:"enabled"`
+	Alternative Alternative            `json:"alternative"`
+	Overrides   map[string]Alternative `json:"overrides"` // by player name, apply even when party mode is off
+}
+
+func NewDefaultParty() PartySettings {
+	return PartySettings{
+		Alternative: Alternative{Kind: ALT_CHALLENGE, Text: "mini-challenge", Deck: "challenges"},
+		Overrides:   map[string]Alternative{},
+	}
+}
+
+func (a Alternative) Validate() error {
+	switch a.Kind {
+	case ALT_DRINK:
+	case ALT_POINTS:
+		if a.Points <= 0 {
+			return errors.New("points alternative needs a positive number of points")
+		}
+	case ALT_FORFEIT, ALT_CHALLENGE:
+		if a.Text == "" && a.Deck == "" {
+			return errors.New("alternative needs text or a deck")
+		}
+	default:
+		return errors.New("unknown alternative kind " + a.Kind)
+	}
+	return nil
+}
+
+// AlternativeFor returns the party alternative for a player's drinks, if any.
+func (r *Room) AlternativeFor(idx int) (Alternative, bool) {
+	alt, ok := r.Party.Overrides[r.PlayerName(idx)]
+	if !ok {
+		if !r.Party.Enabled {
+			return Alternative{}, false
+		}
+		alt = r.Party.Alternative
+	}
+	if alt.Kind == ALT_DRINK {
+		return Alternative{}, false
+	}
+	return alt, true
+}
+
+// PartyOutcomes replaces n drinks for a player with their alternative.
+func (r *Room) PartyOutcomes(idx int, ridx int, target int, alt Alternative, n int) []Outcome {
+	var logs []string
+	if alt.Kind == ALT_POINTS {
+		points := alt.Points * n
+		r.Points[r.PlayerName(target)] += points
+		text := alt.Text
+		if text == "" {
+			text = "penalty points"
+		}
+		logs = r.Combine([]int{target}, Rule{Text: fmt.Sprintf("+%d %s", points, text)}, 1)
+	} else {
+		logs = r.Combine([]int{target}, Rule{Text: alt.Text, Deck: alt.Deck, DeckLevel: DECK_ANY_LEVEL, ScaleWithNum: true}, n)
+	}
+	return GroupOutcomes(idx, ridx, []int{target}, logs)
+}
+
+func (r *Room) ResetPoints() {
+	r.Points = map[string]int{}
+}
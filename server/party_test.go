@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// partyRoom is a room with players a and b, created through the API so party
+// settings can be changed the way clients change them.
+func partyRoom(t *testing.T) (*API, *Room) {
+	t.Helper()
+	api := newTestAPI(t)
+	created, err := api.CreateRoom(CreateRoomRequest{Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, _, err := api.JoinRoom(created.Code, JoinRequest{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return api, api.Rooms.Rooms[created.Code]
+}
+
+func TestPartyOutcomes(t *testing.T) {
+	forfeit := &Alternative{Kind: ALT_FORFEIT, Text: "do a forfeit"}
+	points := &Alternative{Kind: ALT_POINTS, Points: 3}
+	drink := Rule{Text: "take a drink!", ScaleWithNum: true, Drink: true}
+	tests := []struct {
+		name     string
+		enabled  bool
+		override *Alternative
+		clear    bool // whether the override is cleared again
+		rule     Rule
+		want     map[string]int // outcome texts and their counts
+		points   int
+	}{
+		{"party off", false, nil, false, drink, map[string]int{"a: take a drink!": 2}, 0},
+		{"party on", true, nil, false, drink, map[string]int{"a: do a forfeit": 2}, 0},
+		{"override with party off", false, points, false, drink, map[string]int{"a: +6 penalty points": 1}, 6},
+		{"override over party", true, points, false, drink, map[string]int{"a: +6 penalty points": 1}, 6},
+		{"override to keep drinking", true, &Alternative{Kind: ALT_DRINK}, false, drink, map[string]int{"a: take a drink!": 2}, 0},
+		{"override cleared", false, points, true, drink, map[string]int{"a: take a drink!": 2}, 0},
+		{"override cleared to party", true, points, true, drink, map[string]int{"a: do a forfeit": 2}, 0},
+		{"rule without the flag", true, points, false, Rule{Text: "take a drink!", ScaleWithNum: true}, map[string]int{"a: take a drink!": 2}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api, room := partyRoom(t)
+			if err := api.SetParty(room.Code, PartyRequest{Enabled: &test.enabled, Alternative: forfeit}); err != nil {
+				t.Fatal(err)
+			}
+			if test.override != nil {
+				if err := api.SetOverride(room.Code, "a", test.override); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.clear {
+				if err := api.SetOverride(room.Code, "a", nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := map[string]int{}
+			for _, o := range room.RuleOutcomes(0, 0, Event{Player: 0}, test.rule, 2) {
+				got[o.Text] += o.Count
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("outcomes %v, want %v", got, test.want)
+			}
+			if room.Points["a"] != test.points {
+				t.Errorf("a has %d points, want %d", room.Points["a"], test.points)
+			}
+		})
+	}
+}
+
+func TestPointsResetEachGame(t *testing.T) {
+	api, room := partyRoom(t)
+	if err := api.SetOverride(room.Code, "a", &Alternative{Kind: ALT_POINTS, Points: 2}); err != nil {
+		t.Fatal(err)
+	}
+	drink := Rule{Text: "take a drink!", Drink: true}
+	for game := 1; game <= 3; game++ {
+		// Seats are shuffled for every new game
+		_, a := room.GetPlayer("a")
+		for i := 0; i < game; i++ {
+			room.RuleOutcomes(0, 0, Event{Player: a}, drink, 1)
+		}
+		if room.Points["a"] != 2*game {
+			t.Errorf("game %d: a has %d points, want %d", game, room.Points["a"], 2*game)
+		}
+		if err := room.NewGame(); err != nil {
+			t.Fatal(err)
+		}
+		if len(room.Points) != 0 {
+			t.Errorf("points %v kept into game %d", room.Points, game+1)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_RATING     = 1200.0
+	ELO_K              = 32.0
+	PROFILE_SAVE_DELAY = 2 * time.Second // Changes within this long are saved together
+)
+
+var (
+	ErrProfileTaken   = &APIError{http.StatusConflict, "that name already has a profile"}
+	ErrProfileToken   = &APIError{http.StatusForbidden, "wrong profile token"}
+	ErrNoProfileToken = &APIError{http.StatusForbidden, "create a profile first to get a profile token"}
+)
+
+type Profile struct {
+	Name        string    `json:"name"`
+	GamesPlayed int       `json:"games_played"`
+	Wins        int       `json:"wins"`
+	Ties        int       `json:"ties"`
+	Captures    int       `json:"captures"`
+	Rating      float64   `json:"rating"`
+	Updated     time.Time `json:"updated"`
+	tokenHash   string    // of the secret issued when the profile was created
+}
+
+// storedProfile is a profile as it is saved, with its token hash.
+type storedProfile struct {
+	Profile
+	TokenHash string `json:"token_hash,omitempty"`
+}
+
+// ProfileStore keeps player profiles across rooms, saved to a JSON file if
+// it has a path.
+type ProfileStore struct {
+	sync.RWMutex
+	Path     string
+	Profiles map[string]*Profile
+	changed  chan struct{}
+	saving   sync.Mutex // held while the file is written
+}
+
+func NewProfileStore(path string) (*ProfileStore, error) {
+	store := &ProfileStore{Path: path, Profiles: map[string]*Profile{}, changed: make(chan struct{}, 1)}
+	if path == "" {
+		return store, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	profiles := []storedProfile{}
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, err
+	}
+	for _, stored := range profiles {
+		profile := stored.Profile
+		profile.tokenHash = stored.TokenHash
+		store.Profiles[profile.Name] = &profile
+	}
+	return store, nil
+}
+
+// Save writes the store to a temporary file and renames it over the old one
+// so a crash mid-write can't lose every profile. Must be called without the
+// lock held.
+func (s *ProfileStore) Save() error {
+	if s.Path == "" {
+		return nil
+	}
+	s.saving.Lock()
+	defer s.saving.Unlock()
+
+	s.RLock()
+	profiles := []storedProfile{}
+	for _, profile := range s.Leaderboard(0) {
+		profiles = append(profiles, storedProfile{profile, profile.tokenHash})
+	}
+	s.RUnlock()
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+// markChanged asks the saver to write the store.
+func (s *ProfileStore) markChanged() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// RunSaver saves the store a short while after it changes, so moves don't
+// wait on the disk and a burst of finished games is written once. Shutdown
+// saves whatever it hasn't written yet.
+func (s *ProfileStore) RunSaver(delay time.Duration) {
+	for range s.changed {
+		time.Sleep(delay)
+		if err := s.Save(); err != nil {
+			Log.Error("could not save profiles", "error", err)
+		}
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create makes a profile for a name and returns the token that plays as it.
+// Profiles saved before tokens were issued can be claimed once this way.
+// Must be called without the lock held.
+func (s *ProfileStore) Create(name string) (*Profile, string, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(secret)
+
+	s.Lock()
+	defer s.Unlock()
+	profile, ok := s.Profiles[name]
+	if ok && profile.tokenHash != "" {
+		return nil, "", ErrProfileTaken
+	}
+	profile = s.GetOrCreate(name)
+	profile.tokenHash = hashToken(token)
+	s.markChanged()
+	copied := *profile
+	return &copied, token, nil
+}
+
+// Authenticate checks the token of a name's profile. Must be called without
+// the lock held.
+func (s *ProfileStore) Authenticate(name string, token string) error {
+	s.RLock()
+	defer s.RUnlock()
+	profile, ok := s.Profiles[name]
+	if !ok || profile.tokenHash == "" {
+		return ErrNoProfileToken
+	}
+	if subtle.ConstantTimeCompare([]byte(profile.tokenHash), []byte(hashToken(token))) != 1 {
+		return ErrProfileToken
+	}
+	return nil
+}
+
+func (s *ProfileStore) Get(name string) (*Profile, bool) {
+	profile, ok := s.Profiles[name]
+	return profile, ok
+}
+
+func (s *ProfileStore) GetOrCreate(name string) *Profile {
+	profile, ok := s.Profiles[name]
+	if !ok {
+		profile = &Profile{Name: name, Rating: DEFAULT_RATING, Updated: time.Now()}
+		s.Profiles[name] = profile
+	}
+	return profile
+}
+
+// Leaderboard lists profiles by rating, limited to n if n is positive.
+// Must be called with the lock held.
+func (s *ProfileStore) Leaderboard(n int) []Profile {
+	out := []Profile{}
+	for _, profile := range s.Profiles {
+		out = append(out, *profile)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Rating != out[j].Rating {
+			return out[i].Rating > out[j].Rating
+		}
+		return out[i].Name < out[j].Name
+	})
+	if n > 0 && n < len(out) {
+		out = out[:n]
+	}
+	return out
+}
+
+// ExpectedScore is the Elo expected score of a player against an opponent.
+func ExpectedScore(rating float64, opponent float64) float64 {
+	return 1 / (1 + math.Pow(10, (opponent-rating)/400))
+}
+
+// RecordGame updates the profiles of the players in a room that has just
+// finished a game. Ratings change by Elo against every player on another
+// side, scaled so a game is worth the same however many opponents there are.
+// Hotseat games are not recorded since one person plays every seat. The
+// store is saved in the background.
+func (s *ProfileStore) RecordGame(r *Room) {
+	if r.SPMode || len(r.Results) == 0 {
+		return
+	}
+	result := r.Results[len(r.Results)-1]
+	board := r.Board
+	sideScores := board.SideScores()
+	best := 0
+	for _, score := range sideScores {
+		if score > best {
+			best = score
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	ratings := make([]float64, board.NumPlayers)
+	for p := 0; p < board.NumPlayers; p++ {
+		ratings[p] = DEFAULT_RATING
+		if p < len(r.Players) && r.Players[p].Profile {
+			ratings[p] = s.GetOrCreate(r.Players[p].Name).Rating
+		}
+	}
+
+	changed := false
+	for p := 0; p < board.NumPlayers && p < len(r.Players); p++ {
+		if !r.Players[p].Profile {
+			continue
+		}
+		name := r.Players[p].Name
+		profile := s.GetOrCreate(name)
+
+		opponents := board.Opponents(p)
+		delta := 0.0
+		for _, o := range opponents {
+			actual := 0.5
+			if sideScores[board.Side(p)] > sideScores[board.Side(o)] {
+				actual = 1
+			} else if sideScores[board.Side(p)] < sideScores[board.Side(o)] {
+				actual = 0
+			}
+			delta += ELO_K * (actual - ExpectedScore(ratings[p], ratings[o]))
+		}
+		if len(opponents) > 0 {
+			profile.Rating += delta / float64(len(opponents))
+		}
+
+		profile.GamesPlayed += 1
+		profile.Captures += result.Captures[name]
+		if result.Tied && sideScores[board.Side(p)] == best {
+			profile.Ties += 1
+		}
+		for _, winner := range result.Winners {
+			if winner == name {
+				profile.Wins += 1
+			}
+		}
+		profile.Updated = time.Now()
+		changed = true
+	}
+
+	if changed {
+		s.markChanged()
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordGame(t *testing.T) {
+	tests := []struct {
+		name     string
+		players  int
+		teams    bool
+		hotseat  bool
+		profiles []bool
+		ratings  []float64
+		stores   []int
+		want     []float64 // rating after the game, 0 for no profile
+	}{
+		{"equal ratings", 2, false, false, []bool{true, true}, []float64{1200, 1200}, []int{30, 18}, []float64{1216, 1184}},
+		{"upset", 2, false, false, []bool{true, true}, []float64{1200, 1600}, []int{30, 18}, []float64{1229.09, 1570.91}},
+		{"expected win", 2, false, false, []bool{true, true}, []float64{1600, 1200}, []int{30, 18}, []float64{1602.91, 1197.09}},
+		{"tie", 2, false, false, []bool{true, true}, []float64{1300, 1300}, []int{24, 24}, []float64{1300, 1300}},
+		{"tie against a stronger player", 2, false, false, []bool{true, true}, []float64{1200, 1600}, []int{24, 24}, []float64{1213.09, 1586.91}},
+		{"opponent without a profile", 2, false, false, []bool{true, false}, []float64{1200, 0}, []int{18, 30}, []float64{1184, 0}},
+		{"hotseat", 2, false, true, []bool{true, true}, []float64{1200, 1200}, []int{30, 18}, []float64{1200, 1200}},
+		{"four players", 4, false, false, []bool{true, true, true, true}, []float64{1200, 1200, 1200, 1200}, []int{30, 10, 30, 26}, []float64{1210.67, 1184, 1210.67, 1194.67}},
+		{"teams", 4, true, false, []bool{true, true, true, true}, []float64{1200, 1200, 1200, 1200}, []int{30, 10, 30, 26}, []float64{1216, 1184, 1216, 1184}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := NewProfileStore("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			room, err := NewRoom("TEST", test.players, test.hotseat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := room.SetTeams(test.teams); err != nil {
+				t.Fatal(err)
+			}
+			for p := 0; p < test.players; p++ {
+				name := string(rune('a' + p))
+				room.Players = append(room.Players, &Player{Name: name, Profile: test.profiles[p]})
+				if test.profiles[p] {
+					store.GetOrCreate(name).Rating = test.ratings[p]
+				}
+				room.Board.Holes[room.Board.PlayerWinholeIdx(p)].Stones = make([]int, test.stores[p])
+			}
+			room.Results = append(room.Results, GameResult{Captures: map[string]int{}})
+
+			store.RecordGame(room)
+			for p, want := range test.want {
+				profile, ok := store.Get(string(rune('a' + p)))
+				if want == 0 {
+					if ok {
+						t.Errorf("player %d has a profile", p)
+					}
+					continue
+				}
+				if math.Abs(profile.Rating-want) > 0.01 {
+					t.Errorf("player %d rating %.2f, want %.2f", p, profile.Rating, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProfileTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	store, err := NewProfileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, token, err := store.Create("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Create("alice"); err != ErrProfileTaken {
+		t.Errorf("creating a taken profile: got %v, want %v", err, ErrProfileTaken)
+	}
+	// Profiles saved before tokens can be claimed once
+	store.GetOrCreate("bob")
+	_, bobToken, err := store.Create("bob")
+	if err != nil {
+		t.Errorf("claiming a profile without a token: %v", err)
+	}
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewProfileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"alice", token, nil},
+		{"alice", bobToken, ErrProfileToken},
+		{"alice", "", ErrProfileToken},
+		{"bob", bobToken, nil},
+		{"carol", token, ErrNoProfileToken},
+	}
+	for _, test := range tests {
+		for _, s := range []*ProfileStore{store, loaded} {
+			if err := s.Authenticate(test.name, test.token); err != test.want {
+				t.Errorf("%s with token %q: got %v, want %v", test.name, test.token, err, test.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrServerFull = errors.New("server is full, try again later")
+
+// Bucket is a token bucket refilled at PerMinute tokens a minute that holds
+// at most Burst tokens.
+type Bucket struct {
+	PerMinute float64 `json:"per_minute"`
+	Burst     int     `json:"burst"`
+}
+
+// RateLimit limits an endpoint per client IP and, for endpoints that name a
+// player, per player in a room from each IP. Player names aren't
+// authenticated, so keying on the name alone would let anyone use up another
+// player's tokens.
+type RateLimit struct {
+	IP     *Bucket `json:"ip"`
+	Player *Bucket `json:"player"`
+}
+
+func NewDefaultRateLimits() map[string]RateLimit {
+	return map[string]RateLimit{
+		"create":            {IP: &Bucket{PerMinute: 10, Burst: 5}},
+		"join":              {IP: &Bucket{PerMinute: 30, Burst: 10}, Player: &Bucket{PerMinute: 10, Burst: 5}},
+		"ping":              {IP: &Bucket{PerMinute: 30, Burst: 5}, Player: &Bucket{PerMinute: 4, Burst: 2}},
+		"rule":              {IP: &Bucket{PerMinute: 60, Burst: 20}, Player: &Bucket{PerMinute: 30, Burst: 10}},
+		"input":             {IP: &Bucket{PerMinute: 300, Burst: 60}, Player: &Bucket{PerMinute: 120, Burst: 30}},
+		"chat":              {IP: &Bucket{PerMinute: 60, Burst: 20}, Player: &Bucket{PerMinute: 30, Burst: 10}},
+		"deck":              {IP: &Bucket{PerMinute: 30, Burst: 10}},
+		"stones":            {IP: &Bucket{PerMinute: 30, Burst: 10}},
+		"safety":            {IP: &Bucket{PerMinute: 30, Burst: 10}},
+		"party":             {IP: &Bucket{PerMinute: 30, Burst: 10}},
+		"series":            {IP: &Bucket{PerMinute: 30, Burst: 10}},
+		"opt-out":           {IP: &Bucket{PerMinute: 30, Burst: 10}, Player: &Bucket{PerMinute: 10, Burst: 5}},
+		"party-override":    {IP: &Bucket{PerMinute: 30, Burst: 10}, Player: &Bucket{PerMinute: 10, Burst: 5}},
+		"simulate":          {IP: &Bucket{PerMinute: 6, Burst: 3}},
+		"queue":             {IP: &Bucket{PerMinute: 60, Burst: 20}},
+		"tournament/create": {IP: &Bucket{PerMinute: 5, Burst: 3}},
+		"ssh":               {IP: &Bucket{PerMinute: 10, Burst: 5}},
+		"profile/create":    {IP: &Bucket{PerMinute: 5, Burst: 3}},
+	}
+}
+
+func (b *Bucket) Validate() error {
+	if b.PerMinute <= 0 || b.Burst < 1 {
+		return errors.New("rate limits need a positive rate and a burst of at least 1")
+	}
+	return nil
+}
+
+type tokens struct {
+	count float64
+	last  time.Time
+}
+
+// Limiter keeps a token bucket for every key it has seen recently.
+type Limiter struct {
+	sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*tokens
+	pruned  time.Time
+}
+
+func NewLimiter(b Bucket) *Limiter {
+	return &Limiter{rate: b.PerMinute / 60, burst: float64(b.Burst), buckets: map[string]*tokens{}, pruned: time.Now()}
+}
+
+// Allow takes a token for the key if there is one, otherwise it returns how
+// long until there will be.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	l.prune(now)
+
+	t, ok := l.buckets[key]
+	if !ok {
+		t = &tokens{count: l.burst, last: now}
+		l.buckets[key] = t
+	}
+	t.count = math.Min(l.burst, t.count+now.Sub(t.last).Seconds()*l.rate)
+	t.last = now
+	if t.count >= 1 {
+		t.count -= 1
+		return true, 0
+	}
+	wait := time.Duration((1 - t.count) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// prune forgets buckets that have refilled, so the map doesn't grow with every
+// client ever seen. Must be called with the lock held.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	l.pruned = now
+	for key, t := range l.buckets {
+		if t.count+now.Sub(t.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// ClientIP is the address a request came from. Behind a proxy like Heroku's
+// router the last X-Forwarded-For entry is the one the proxy added, earlier
+// ones come from the client and can't be trusted.
+func ClientIP(r *http.Request) string {
+	if config.TrustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			parts := strings.Split(forwarded, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type endpointLimiter struct {
+	ip     *Limiter
+	player *Limiter
+}
+
+// RateLimits holds the limiters of every rate limited endpoint.
+type RateLimits struct {
+	endpoints map[string]*endpointLimiter
+}
+
+func NewRateLimits(limits map[string]RateLimit) *RateLimits {
+	rl := &RateLimits{endpoints: map[string]*endpointLimiter{}}
+	for name, limit := range limits {
+		e := &endpointLimiter{}
+		if limit.IP != nil {
+			e.ip = NewLimiter(*limit.IP)
+		}
+		if limit.Player != nil {
+			e.player = NewLimiter(*limit.Player)
+		}
+		rl.endpoints[name] = e
+	}
+	return rl
+}
+
+// playerKey reads the room code and player name from a request body, putting
+// the body back for the handler. API routes have the room code, and some the
+// player name, in the path.
+func playerKey(r *http.Request) string {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MAX_BODY_SIZE))
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	var req struct {
+		Code   string
+		Name   string
+		Player string
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	if code := PathParam(r, "code"); code != "" {
+		req.Code = code
+	}
+	if req.Code == "" {
+		return ""
+	}
+	name := req.Name
+	if name == "" {
+		name = req.Player
+	}
+	if path := PathParam(r, "name"); path != "" {
+		name = path
+	}
+	return NormalizeCode(req.Code) + "/" + name
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	WriteError(w, fmt.Sprintf("too many requests, try again in %.0f seconds", math.Ceil(wait.Seconds())), http.StatusTooManyRequests)
+}
+
+// Allow takes a token for a client IP, and for a player key from that IP
+// unless it's empty, from the limits configured for the endpoint name.
+// Otherwise it returns how long until the client may try again.
+func (rl *RateLimits) Allow(name string, ip string, player string) (bool, time.Duration) {
+	e, ok := rl.endpoints[name]
+	if !ok {
+		return true, 0
+	}
+	if e.ip != nil {
+		if ok, wait := e.ip.Allow(ip); !ok {
+			return false, wait
+		}
+	}
+	if e.player != nil && player != "" {
+		if ok, wait := e.player.Allow(ip + " " + player); !ok {
+			return false, wait
+		}
+	}
+	return true, 0
+}
+
+// Wrap rate limits a handler by the limits configured for the endpoint name.
+// Every method but a preflight counts, since the original endpoints take GET
+// as well as POST for changes. Routes that only read aren't wrapped.
+func (rl *RateLimits) Wrap(name string, handler http.HandlerFunc) http.HandlerFunc {
+	e, ok := rl.endpoints[name]
+	if !ok {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Preflight requests are left to the handler and don't count
+		if r.Method == http.MethodOptions {
+			handler(w, r)
+			return
+		}
+		if !corsHeaders(w, r) {
+			return
+		}
+		key := ""
+		if e.player != nil {
+			key = playerKey(r)
+		}
+		if ok, wait := rl.Allow(name, ClientIP(r), key); !ok {
+			tooManyRequests(w, wait)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// openConns counts websocket connections across the server.
+var openConns int64
+
+// AcquireConn reserves a websocket connection, failing when the server is at
+// its limit.
+func AcquireConn() bool {
+	if atomic.AddInt64(&openConns, 1) > int64(config.Limits.MaxConns) {
+		atomic.AddInt64(&openConns, -1)
+		return false
+	}
+	return true
+}
+
+func ReleaseConn() {
+	atomic.AddInt64(&openConns, -1)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		bucket  Bucket
+		idle    time.Duration // time passed after using up the burst
+		allowed int           // further requests allowed after idling
+	}{
+		{"no refill", Bucket{PerMinute: 60, Burst: 3}, 0, 0},
+		{"partial token", Bucket{PerMinute: 60, Burst: 3}, 500 * time.Millisecond, 0},
+		{"one token", Bucket{PerMinute: 60, Burst: 3}, 1100 * time.Millisecond, 1},
+		{"two tokens", Bucket{PerMinute: 60, Burst: 3}, 2100 * time.Millisecond, 2},
+		{"capped at burst", Bucket{PerMinute: 60, Burst: 3}, time.Hour, 3},
+		{"slow refill", Bucket{PerMinute: 1, Burst: 1}, 30 * time.Second, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := NewLimiter(test.bucket)
+			for i := 0; i < test.bucket.Burst; i++ {
+				if ok, _ := l.Allow("key"); !ok {
+					t.Fatalf("request %d of the burst refused", i+1)
+				}
+			}
+			ok, wait := l.Allow("key")
+			if ok || wait <= 0 {
+				t.Fatalf("request over the burst got %v, wait %v", ok, wait)
+			}
+			if other, _ := l.Allow("other"); !other {
+				t.Errorf("another key shares the bucket")
+			}
+
+			l.buckets["key"].last = time.Now().Add(-test.idle)
+			allowed := 0
+			for ok, _ := l.Allow("key"); ok; ok, _ = l.Allow("key") {
+				allowed += 1
+			}
+			if allowed != test.allowed {
+				t.Errorf("%d requests allowed after %v, want %d", allowed, test.idle, test.allowed)
+			}
+		})
+	}
+}
+
+func TestRateLimitsAllow(t *testing.T) {
+	limits := NewRateLimits(map[string]RateLimit{
+		"move": {IP: &Bucket{PerMinute: 1, Burst: 3}, Player: &Bucket{PerMinute: 1, Burst: 1}},
+	})
+	steps := []struct {
+		endpoint string
+		ip       string
+		player   string
+		ok       bool
+	}{
+		{"move", "1.1.1.1", "room/ada", true},
+		{"move", "1.1.1.1", "room/ada", false}, // ada's bucket from this IP is empty
+		{"move", "2.2.2.2", "room/ada", true},  // someone else using ada's name doesn't share it
+		{"move", "1.1.1.1", "room/bob", true},
+		{"move", "1.1.1.1", "", false}, // the IP's bucket is empty too
+		{"unlimited", "1.1.1.1", "room/ada", true},
+	}
+	for i, step := range steps {
+		if ok, _ := limits.Allow(step.endpoint, step.ip, step.player); ok != step.ok {
+			t.Errorf("step %d: %s from %s for %q allowed %v, want %v", i, step.endpoint, step.ip, step.player, ok, step.ok)
+		}
+	}
+}
+
+func TestRateLimitsWrap(t *testing.T) {
+	api := newTestAPI(t)
+	if _, err := api.CreateRoom(CreateRoomRequest{Size: 2}); err != nil {
+		t.Fatal(err)
+	}
+	code := ""
+	for code = range api.Rooms.Rooms {
+	}
+	limits := NewRateLimits(NewDefaultRateLimits())
+	v1 := NewV1Router(api, limits)
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		method   string
+		path     string
+		body     string
+		requests int
+		limited  int // number of the first request refused, 0 for none
+	}{
+		{"legacy create by GET", limits.Wrap("create", HandleCreate(api)), http.MethodGet, "/api/create", `{"size": 2}`, 8, 6},
+		{"legacy create preflight", limits.Wrap("create", HandleCreate(api)), http.MethodOptions, "/api/create", "", 20, 0},
+		{"legacy safety", limits.Wrap("safety", HandleSafety(api)), http.MethodPost, "/api/safety", `{"code": "` + code + `"}`, 12, 11},
+		{"v1 chat reads", v1.ServeHTTP, http.MethodGet, "/api/v1/rooms/" + code + "/chat", "", 50, 0},
+		// Only the path names the player, so the player's bucket runs out first
+		{"v1 opt-out by player", v1.ServeHTTP, http.MethodPut, "/api/v1/rooms/" + code + "/players/ada/opt-out", `{"opt_out": true}`, 8, 6},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limited := 0
+			for i := 1; i <= test.requests && limited == 0; i++ {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+				r.RemoteAddr = "192.0.2.1:1234"
+				test.handler(w, r)
+				if w.Code == http.StatusTooManyRequests {
+					limited = i
+					if w.Header().Get("Retry-After") == "" {
+						t.Errorf("no Retry-After header")
+					}
+				}
+			}
+			if limited != test.limited {
+				t.Errorf("request %d refused, want %d", limited, test.limited)
+			}
+		})
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	cfg, err := LoadConfig([]string{"-config", "config.example.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := NewDefaultConfig()
+	if !reflect.DeepEqual(cfg.RateLimits, defaults.RateLimits) {
+		t.Errorf("example rate limits differ from the defaults:\n%+v\n%+v", cfg.RateLimits, defaults.RateLimits)
+	}
+	if cfg.Limits != defaults.Limits {
+		t.Errorf("example limits %+v differ from the defaults %+v", cfg.Limits, defaults.Limits)
+	}
+}
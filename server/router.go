@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type paramsKey struct{}
+
+type route struct {
+	method  string
+	parts   []string // "{name}" parts match any segment
+	handler http.HandlerFunc
+}
+
+// Router dispatches requests under a prefix by method and path, with path
+// parameters like /rooms/{code}. It answers CORS preflights and unknown paths
+// and methods itself, so its handlers only see requests they can serve.
+type Router struct {
+	prefix string
+	routes []route
+}
+
+func NewRouter(prefix string) *Router {
+	return &Router{prefix: strings.TrimSuffix(prefix, "/")}
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+func (rt *Router) Handle(method string, pattern string, handler http.HandlerFunc) {
+	rt.routes = append(rt.routes, route{method, splitPath(pattern), handler})
+}
+
+// match returns the path parameters if the route matches the path segments.
+func (rt *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.parts) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, part := range rt.parts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			value, err := url.PathUnescape(segments[i])
+			if err != nil || value == "" {
+				return nil, false
+			}
+			params[part[1:len(part)-1]] = value
+		} else if part != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !corsHeaders(w, r) {
+		return
+	}
+
+	// Segments are split before unescaping so names may contain slashes
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, rt.prefix+"/") && path != rt.prefix {
+		WriteError(w, "no such endpoint "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+	segments := splitPath(strings.TrimPrefix(path, rt.prefix))
+
+	methods := []string{}
+	for i := range rt.routes {
+		params, ok := rt.routes[i].match(segments)
+		if !ok {
+			continue
+		}
+		if rt.routes[i].method == r.Method {
+			rt.routes[i].handler(w, r.WithContext(context.WithValue(r.Context(), paramsKey{}, params)))
+			return
+		}
+		methods = append(methods, rt.routes[i].method)
+	}
+	if len(methods) == 0 {
+		WriteError(w, "no such endpoint "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+
+	allowed := strings.Join(append(methods, http.MethodOptions), ", ")
+	w.Header().Set("Access-Control-Allow-Methods", allowed)
+	if r.Method == http.MethodOptions {
+		preflight(w)
+		return
+	}
+	methodNotAllowed(w, r, allowed)
+}
+
+// PathParam returns a parameter from the path of a routed request.
+func PathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+type SafetySettings struct {
+	MaxPerWindow int    `json:"max_per_window"` // 0 - no limit
+	WindowSecs   int    `json:"window_secs"`
+	MaxPerGame   int    `json:"max_per_game"` // 0 - no limit
+	Alternative  string `json:"alternative"`  // given instead of a drink over the limits or to opted out players
+}
+
+type DrinkRecord struct {
+	Name string
+	Time time.Time
+}
+
+// Pacing is how close a player is to the room's drink limits.
+type Pacing struct {
+	Name         string `json:"name"`
+	GameDrinks   int    `json:"game_drinks"`
+	WindowDrinks int    `json:"window_drinks"`
+	MaxPerGame   int    `json:"max_per_game"`
+	MaxPerWindow int    `json:"max_per_window"`
+	OptOut       bool   `json:"opt_out"`
+}
+
+// NewDefaultSafety has no drink limits, the room's creator turns them on with
+// the window and alternative already filled in.
+func NewDefaultSafety() SafetySettings {
+	return SafetySettings{
+		WindowSecs:  30 * 60,
+		Alternative: "drink a glass of water",
+	}
+}
+
+func (s SafetySettings) Validate() error {
+	if s.MaxPerWindow < 0 || s.MaxPerGame < 0 {
+		return errors.New("drink limits can not be negative")
+	}
+	if s.MaxPerWindow > 0 && s.WindowSecs <= 0 {
+		return errors.New("drink window must be positive")
+	}
+	if s.Alternative == "" {
+		return errors.New("an alternative to drinking is required")
+	}
+	return nil
+}
+
+func (s SafetySettings) Window() time.Duration {
+	return time.Duration(s.WindowSecs) * time.Second
+}
+
+// WindowDrinks counts a player's drinks within the safety window.
+func (r *Room) WindowDrinks(name string) int {
+	cutoff := time.Now().Add(-r.Safety.Window())
+	n := 0
+	for _, d := range r.Drinks {
+		if d.Name == name && !d.Time.Before(cutoff) {
+			n += 1
+		}
+	}
+	return n
+}
+
+// DrinksAllowed is how many of n drinks a player can take before reaching a limit.
+func (r *Room) DrinksAllowed(idx int, n int) int {
+	if idx < len(r.Players) && r.Players[idx].OptOut {
+		return 0
+	}
+	name := r.PlayerName(idx)
+	if r.Safety.MaxPerGame > 0 {
+		if left := r.Safety.MaxPerGame - r.GameDrinks[name]; left < n {
+			n = left
+		}
+	}
+	if r.Safety.MaxPerWindow > 0 {
+		if left := r.Safety.MaxPerWindow - r.WindowDrinks(name); left < n {
+			n = left
+		}
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// RecordDrinks adds drinks to a player's tally, dropping records that have
+// aged out of the safety window.
+func (r *Room) RecordDrinks(idx int, n int) {
+	name := r.PlayerName(idx)
+	now := time.Now()
+	cutoff := now.Add(-r.Safety.Window())
+	kept := []DrinkRecord{}
+	for _, d := range r.Drinks {
+		if !d.Time.Before(cutoff) {
+			kept = append(kept, d)
+		}
+	}
+	for i := 0; i < n; i++ {
+		kept = append(kept, DrinkRecord{Name: name, Time: now})
+	}
+	r.Drinks = kept
+	r.GameDrinks[name] += n
+}
+
+// DrinkOutcomes gives each target of a drink rule their own prompt so their
+// limits apply separately, substituting the alternative for drinks over them.
+// Players in party mode get their party alternative instead.
+func (r *Room) DrinkOutcomes(idx int, ridx int, targets []int, rule Rule, v int) []Outcome {
+	outcomes := []Outcome{}
+	for _, target := range targets {
+		logs := r.Combine([]int{target}, rule, v)
+		if alt, ok := r.AlternativeFor(target); ok {
+			outcomes = append(outcomes, r.PartyOutcomes(idx, ridx, target, alt, len(logs))...)
+			continue
+		}
+
+		allowed := r.DrinksAllowed(target, len(logs))
+		r.RecordDrinks(target, allowed)
+		outcomes = append(outcomes, GroupOutcomes(idx, ridx, []int{target}, logs[:allowed])...)
+
+		if substituted := len(logs) - allowed; substituted > 0 {
+			alt := Rule{Text: r.Safety.Alternative, ScaleWithNum: true}
+			outcomes = append(outcomes, GroupOutcomes(idx, ridx, []int{target}, r.Combine([]int{target}, alt, substituted))...)
+		}
+	}
+	return outcomes
+}
+
+func (r *Room) ResetGameDrinks() {
+	r.GameDrinks = map[string]int{}
+}
+
+func (r *Room) Pacing() []Pacing {
+	pacing := []Pacing{}
+	for _, player := range r.Players {
+		pacing = append(pacing, Pacing{
+			Name:         player.Name,
+			GameDrinks:   r.GameDrinks[player.Name],
+			WindowDrinks: r.WindowDrinks(player.Name),
+			MaxPerGame:   r.Safety.MaxPerGame,
+			MaxPerWindow: r.Safety.MaxPerWindow,
+			OptOut:       player.OptOut,
+		})
+	}
+	return pacing
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDrinkOutcomes(t *testing.T) {
+	tests := []struct {
+		name        string
+		safety      SafetySettings
+		earlier     []time.Duration // how long ago the player's earlier drinks this game were
+		newGame     bool            // whether a new game started after the earlier drinks
+		optOut      bool
+		drinks      int
+		drunk       int
+		substituted int
+	}{
+		{"no limits", SafetySettings{}, []time.Duration{0, 0, 0}, false, false, 5, 5, 0},
+		{"game cap", SafetySettings{MaxPerGame: 3}, []time.Duration{0}, false, false, 5, 2, 3},
+		{"game cap reached", SafetySettings{MaxPerGame: 3}, []time.Duration{0, 0, 0}, false, false, 2, 0, 2},
+		{"game cap resets", SafetySettings{MaxPerGame: 3}, []time.Duration{0, 0, 0}, true, false, 2, 2, 0},
+		{"window cap", SafetySettings{MaxPerWindow: 4, WindowSecs: 600}, []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute}, false, false, 3, 1, 2},
+		{"window cap across games", SafetySettings{MaxPerWindow: 4, WindowSecs: 600}, []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute}, true, false, 3, 1, 2},
+		{"drinks out of the window", SafetySettings{MaxPerWindow: 4, WindowSecs: 600}, []time.Duration{20 * time.Minute, 20 * time.Minute, 20 * time.Minute}, false, false, 3, 3, 0},
+		{"opted out", SafetySettings{}, nil, false, true, 2, 0, 2},
+	}
+	rule := Rule{Text: "take a drink!", ScaleWithNum: true, Drink: true}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room, err := NewRoom("TEST", 2, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			room.Players = append(room.Players, &Player{Name: "a", OptOut: test.optOut}, &Player{Name: "b"})
+			room.Safety.MaxPerGame = test.safety.MaxPerGame
+			room.Safety.MaxPerWindow = test.safety.MaxPerWindow
+			if test.safety.WindowSecs > 0 {
+				room.Safety.WindowSecs = test.safety.WindowSecs
+			}
+			for _, ago := range test.earlier {
+				room.Drinks = append(room.Drinks, DrinkRecord{Name: "a", Time: time.Now().Add(-ago)})
+			}
+			room.GameDrinks["a"] = len(test.earlier)
+			if test.newGame {
+				room.ResetGameDrinks()
+			}
+			before := room.GameDrinks["a"]
+
+			drunk, substituted := 0, 0
+			for _, o := range room.DrinkOutcomes(0, 0, []int{0}, rule, test.drinks) {
+				switch o.Text {
+				case "a: take a drink!":
+					drunk += o.Count
+				case "a: " + room.Safety.Alternative:
+					substituted += o.Count
+				default:
+					t.Errorf("unexpected outcome %q", o.Text)
+				}
+			}
+			if drunk != test.drunk || substituted != test.substituted {
+				t.Errorf("%d drunk and %d substituted, want %d and %d", drunk, substituted, test.drunk, test.substituted)
+			}
+			if got := room.GameDrinks["a"] - before; got != drunk {
+				t.Errorf("%d drinks recorded, want %d", got, drunk)
+			}
+		})
+	}
+}
+
+func TestRuleDrinkDefault(t *testing.T) {
+	tests := []struct {
+		json  string
+		drink bool
+	}{
+		{`{"text": "take a drink!"}`, true},
+		{`{"text": "two Shots"}`, true},
+		{`{"text": "sip"}`, true},
+		{`{"text": "say a nice thing"}`, false},
+		{`{"text": "drinking song"}`, false},
+		{`{"text": "drink", "drink": false}`, false},
+		{`{"text": "sing", "drink": true}`, true},
+		{`{"text": "drink a card", "deck": "truths"}`, false},
+	}
+	for _, test := range tests {
+		var rule Rule
+		if err := json.Unmarshal([]byte(test.json), &rule); err != nil {
+			t.Fatal(err)
+		}
+		if rule.Drink != test.drink || rule.DeckLevel != DECK_ANY_LEVEL {
+			t.Errorf("%s decoded to drink %v, deck level %d, want %v", test.json, rule.Drink, rule.DeckLevel, test.drink)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const MAX_SERIES_LENGTH = 15
+
+var ErrGameInProgress = errors.New("finish the current game before starting a series")
+
+type GameResult struct {
+	Game     int            `json:"game"`
+	Scores   map[string]int `json:"scores"` // stones in each player's winhole
+	Winners  []string       `json:"winners"`
+	Tied     bool           `json:"tied"`
+	Captures map[string]int `json:"captures"`
+	Moves    int            `json:"moves"`
+	Time     time.Time      `json:"time"`
+}
+
+// Series is a best-of-N match played in a room. Seats stay fixed between
+// games and the first player rotates instead of being random.
+type Series struct {
+	BestOf      int            `json:"best_of"`
+	FirstGame   int            `json:"first_game"` // room game number the series started on
+	FirstPlayer int            `json:"first_player"`
+	Wins        map[string]int `json:"wins"`
+	Ties        int            `json:"ties"`
+	Played      int            `json:"played"`
+	Finished    bool           `json:"finished"`
+	Winners     []string       `json:"winners"`
+}
+
+func NewSeries(bestOf int, game int, firstPlayer int) (*Series, error) {
+	if bestOf < 1 || bestOf > MAX_SERIES_LENGTH {
+		return nil, fmt.Errorf("series must be between 1 and %d games", MAX_SERIES_LENGTH)
+	}
+	return &Series{
+		BestOf:      bestOf,
+		FirstGame:   game,
+		FirstPlayer: firstPlayer,
+		Wins:        map[string]int{},
+		Winners:     []string{},
+	}, nil
+}
+
+// StartSeries begins a series with the current game if it hasn't been played
+// yet, otherwise with a new one.
+func (r *Room) StartSeries(bestOf int) error {
+	if r.Board.Turn > 0 && !r.Board.Finished {
+		return ErrGameInProgress
+	}
+	if r.Board.Finished {
+		r.Series = nil
+		if err := r.NewGame(); err != nil {
+			return err
+		}
+	}
+	series, err := NewSeries(bestOf, r.GameNumber, r.Board.CurrentPlayer)
+	if err != nil {
+		return err
+	}
+	r.Series = series
+	r.AddHistory(NewHistoryMessage(fmt.Sprintf("Best of %d series started!", bestOf)))
+	return nil
+}
+
+// RecordResult stores the result of a finished game and, if the game decides
+// a series, returns the series victory events.
+func (r *Room) RecordResult(evs []Event) []Event {
+	result := GameResult{
+		Game:     r.GameNumber,
+		Scores:   map[string]int{},
+		Winners:  []string{},
+		Captures: map[string]int{},
+		Moves:    r.Board.Turn,
+		Time:     time.Now(),
+	}
+	countCaptures := func(evs []Event) {
+		for _, ev := range evs {
+			if ev.Eaten > 0 {
+				result.Captures[r.PlayerName(ev.Player)] += 1
+			}
+		}
+	}
+	for _, entry := range r.History {
+		if entry.Game == r.GameNumber {
+			countCaptures(entry.Events)
+		}
+	}
+	countCaptures(evs)
+	for p, score := range r.Board.Scores() {
+		result.Scores[r.PlayerName(p)] = score
+	}
+	for _, ev := range evs {
+		if ev.Victory == 1 {
+			result.Winners = append(result.Winners, r.PlayerName(ev.Player))
+		} else if ev.Victory == 2 {
+			result.Tied = true
+		}
+	}
+	r.Results = append(r.Results, result)
+
+	s := r.Series
+	if s == nil || s.Finished {
+		return nil
+	}
+	s.Played += 1
+	if result.Tied {
+		s.Ties += 1
+	}
+	for _, name := range result.Winners {
+		s.Wins[name] += 1
+	}
+
+	best := 0
+	for p := 0; p < r.Board.NumPlayers; p++ {
+		if wins := s.Wins[r.PlayerName(p)]; wins > best {
+			best = wins
+		}
+	}
+	if best <= s.BestOf/2 && s.Played < s.BestOf {
+		return nil
+	}
+
+	s.Finished = true
+	leaders := 0
+	for p := 0; p < r.Board.NumPlayers; p++ {
+		if s.Wins[r.PlayerName(p)] == best {
+			leaders += 1
+		}
+	}
+	// Teammates share their wins, so a team counts once as a leader
+	if r.Board.Teams != nil {
+		leaders = leaders / len(r.Board.SideMembers(0))
+	}
+	sevs := []Event{}
+	for p := 0; p < r.Board.NumPlayers; p++ {
+		wins := s.Wins[r.PlayerName(p)]
+		vic := wins - best
+		if wins == best && leaders == 1 {
+			vic = 1
+			s.Winners = append(s.Winners, r.PlayerName(p))
+		} else if wins == best {
+			vic = 2
+		}
+		sevs = append(sevs, Event{SeriesVictory: vic, Player: p})
+	}
+	return sevs
+}
+
+// Summary describes the standings of the series.
+func (r *Room) SeriesSummary() string {
+	s := r.Series
+	if s == nil {
+		return ""
+	}
+	names := []string{}
+	for p := 0; p < r.Board.NumPlayers; p++ {
+		names = append(names, r.PlayerName(p))
+	}
+	sort.SliceStable(names, func(i, j int) bool { return s.Wins[names[i]] > s.Wins[names[j]] })
+	standings := []string{}
+	for _, name := range names {
+		standings = append(standings, fmt.Sprintf("%s %d", name, s.Wins[name]))
+	}
+	text := fmt.Sprintf("Series after %d of %d games: %s", s.Played, s.BestOf, strings.Join(standings, ", "))
+	if s.Ties > 0 {
+		text += fmt.Sprintf(" (%d tied)", s.Ties)
+	}
+	if s.Finished {
+		if len(s.Winners) > 0 {
+			text += "\nSeries won by " + strings.Join(s.Winners, " and ") + "!"
+		} else {
+			text += "\nSeries tied!"
+		}
+	}
+	return text
+}
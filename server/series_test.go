@@ -0,0 +1,139 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSeriesWins(t *testing.T) {
+	const tie = -1
+	tests := []struct {
+		name    string
+		bestOf  int
+		winners []int // winner of each game, or tie
+		decided int   // game that decided the series
+		want    []int // series victory of each player
+	}{
+		{"best of one", 1, []int{1}, 1, []int{-1, 1}},
+		{"two straight wins", 3, []int{0, 0}, 2, []int{1, -2}},
+		{"won in the last game", 3, []int{0, 1, 0}, 3, []int{1, -1}},
+		{"won after ties", 3, []int{tie, 0, tie}, 3, []int{1, -1}},
+		{"every game tied", 3, []int{tie, tie, tie}, 3, []int{2, 2}},
+		{"tied series", 5, []int{0, 1, 0, 1, tie}, 5, []int{2, 2}},
+		{"comeback", 5, []int{0, 0, 1, 1, 1}, 5, []int{-1, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room := newTestRoom(t, 2, []int{4, 4, 4, 4, 4, 4, 0, 4, 4, 4, 4, 4, 4, 0})
+			if err := room.StartSeries(test.bestOf); err != nil {
+				t.Fatal(err)
+			}
+			for game, winner := range test.winners {
+				evs := []Event{}
+				for p := 0; p < 2; p++ {
+					vic := -1
+					if winner == tie {
+						vic = 2
+					} else if winner == p {
+						vic = 1
+					}
+					evs = append(evs, Event{Victory: vic, Player: p})
+				}
+				sevs := room.RecordResult(evs)
+				if game+1 < test.decided {
+					if len(sevs) > 0 || room.Series.Finished {
+						t.Fatalf("series decided after game %d, want game %d", game+1, test.decided)
+					}
+					continue
+				}
+				if !room.Series.Finished {
+					t.Fatalf("series not decided after game %d", game+1)
+				}
+				got := eventValues(sevs, func(ev Event) int { return ev.SeriesVictory })
+				if !reflect.DeepEqual(got, test.want) {
+					t.Errorf("series victories %v, want %v", got, test.want)
+				}
+			}
+			if len(room.Results) != len(test.winners) {
+				t.Errorf("%d results recorded, want %d", len(room.Results), len(test.winners))
+			}
+		})
+	}
+}
+
+func TestSeriesFirstPlayer(t *testing.T) {
+	tests := []struct {
+		name    string
+		players int
+		first   int
+		want    []int // first player of each game
+	}{
+		{"two players", 2, 1, []int{1, 0, 1, 0}},
+		{"four players", 4, 2, []int{2, 3, 0, 1, 2}},
+		{"six players", 6, 5, []int{5, 0, 1, 2, 3, 4, 5}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room, err := NewRoom("TEST", test.players, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for p := 0; p < test.players; p++ {
+				room.Players = append(room.Players, &Player{Name: string(rune('a' + p))})
+			}
+			seats := append([]*Player{}, room.Players...)
+			room.Board.CurrentPlayer = test.first
+			if err := room.StartSeries(len(test.want)); err != nil {
+				t.Fatal(err)
+			}
+			got := []int{room.Board.CurrentPlayer}
+			for len(got) < len(test.want) {
+				room.Board.Finished = true
+				if err := room.NewGame(); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, room.Board.CurrentPlayer)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("first players %v, want %v", got, test.want)
+			}
+			if !reflect.DeepEqual(room.Players, seats) {
+				t.Error("seats changed during the series")
+			}
+		})
+	}
+}
+
+func TestSeriesStart(t *testing.T) {
+	tests := []struct {
+		name     string
+		bestOf   int
+		turn     int
+		finished bool
+		err      bool
+		game     int // room game number the series starts on
+	}{
+		{"before the first move", 3, 0, false, false, 1},
+		{"after a finished game", 3, 20, true, false, 2},
+		{"game in progress", 3, 5, false, true, 1},
+		{"too short", 0, 0, false, true, 1},
+		{"too long", MAX_SERIES_LENGTH + 1, 0, false, true, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room, err := NewRoom("TEST", 2, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			room.Board.Turn = test.turn
+			room.Board.Finished = test.finished
+			err = room.StartSeries(test.bestOf)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v, want error %v", err, test.err)
+			}
+			if err == nil && room.Series.FirstGame != test.game {
+				t.Errorf("series starts on game %d, want %d", room.Series.FirstGame, test.game)
+			}
+		})
+	}
+}
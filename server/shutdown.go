@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const SHUTDOWN_TIMEOUT = 20 * time.Second // Default, Heroku kills the process 30 seconds after SIGTERM
+
+var ErrShuttingDown = errors.New("server is restarting, try again in a moment")
+
+// Restart is sent to websocket clients before the server closes their
+// connection, so they know to reconnect rather than treat it as an error.
+type Restart struct {
+	Restart bool `json:"restart"`
+}
+
+func HandleHealth() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(struct {
+			Status string `json:"status"`
+		}{"ok"})
+	}
+}
+
+// HandleReady reports whether the server is taking new games, which stops as
+// soon as it starts shutting down.
+func HandleReady(rooms *LockedRooms) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		rooms.RLock()
+		closed := rooms.Closed
+		rooms.RUnlock()
+		if closed {
+			WriteError(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(struct {
+			Status string `json:"status"`
+		}{"ready"})
+	}
+}
+
+// closeConns tells clients the server is restarting and closes their
+// connections.
+func closeConns(conns map[Conn]bool, deadline time.Time) {
+	msg := websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server restarting")
+	for conn := range conns {
+		if ws, ok := conn.(*websocket.Conn); ok {
+			ws.SetWriteDeadline(deadline)
+			ws.WriteJSON(Restart{true})
+			ws.WriteControl(websocket.CloseMessage, msg, deadline)
+		} else {
+			conn.WriteJSON(Restart{true})
+		}
+		conn.Close()
+		delete(conns, conn)
+	}
+}
+
+// Shutdown stops new rooms from being created, tells websocket clients the
+// server is restarting, waits for requests in flight to finish and then
+// saves profiles, all within the configured shutdown timeout.
+func Shutdown(srv *http.Server, rooms *LockedRooms, tournaments *LockedTournaments, profiles *ProfileStore) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout.Duration)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	rooms.Lock()
+	rooms.Closed = true
+	all := []*Room{}
+	for _, room := range rooms.Rooms {
+		all = append(all, room)
+	}
+	rooms.Unlock()
+
+	for _, room := range all {
+		room.Lock()
+		for _, player := range room.Players {
+			closeConns(player.Conns, deadline)
+		}
+		room.Unlock()
+	}
+
+	tournaments.RLock()
+	watched := []*Tournament{}
+	for _, t := range tournaments.Tournaments {
+		watched = append(watched, t)
+	}
+	tournaments.RUnlock()
+	for _, t := range watched {
+		t.Lock()
+		closeConns(t.Conns, deadline)
+		t.Unlock()
+	}
+
+	if err := srv.Shutdown(ctx); err != nil {
+		Log.Error("could not finish requests before shutting down", "error", err)
+	}
+
+	if err := profiles.Save(); err != nil {
+		Log.Error("could not save profiles", "error", err)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	SIM_DEFAULT_GAMES = 1000
+	SIM_MAX_GAMES     = 10000
+	SIM_MAX_API_GAMES = 500 // Requests to the server default to and are capped at this
+	SIM_API_TIMEOUT   = 10 * time.Second
+	SIM_MAX_MOVES     = 1000 // Guards against a variant that never ends
+)
+
+type SimulationConfig struct {
+	Size   int         `json:"size"`
+	Teams  bool        `json:"teams"`
+	Games  int         `json:"games"`
+	Rules  []Rule      `json:"rules"`
+	Stones []StoneType `json:"stones"`
+}
+
+type Distribution struct {
+	Mean float64 `json:"mean"`
+	Min  int     `json:"min"`
+	P50  int     `json:"p50"`
+	P90  int     `json:"p90"`
+	Max  int     `json:"max"`
+}
+
+type RuleStats struct {
+	Rule    int            `json:"rule"`
+	Text    string         `json:"text"`
+	Fired   int            `json:"fired"`   // total prompts across all games
+	Games   int            `json:"games"`   // games in which the rule fired at least once
+	Players []Distribution `json:"players"` // prompts per game for each seat
+}
+
+type SimulationReport struct {
+	Games      int          `json:"games"`
+	GameLength Distribution `json:"game_length"` // moves per game
+	Wins       []int        `json:"wins"`        // outright wins for each seat
+	Ties       int          `json:"ties"`
+	Rules      []RuleStats  `json:"rules"`
+}
+
+func NewDistribution(samples []int) Distribution {
+	if len(samples) == 0 {
+		return Distribution{}
+	}
+	sorted := append([]int{}, samples...)
+	sort.Ints(sorted)
+	total := 0
+	for _, v := range sorted {
+		total += v
+	}
+	pick := func(p float64) int {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	return Distribution{
+		Mean: float64(total) / float64(len(sorted)),
+		Min:  sorted[0],
+		P50:  pick(0.5),
+		P90:  pick(0.9),
+		Max:  sorted[len(sorted)-1],
+	}
+}
+
+// RandomMove picks a legal hole for the current player.
+func RandomMove(g *GameBoard) int {
+	moves := []int{}
+	for idx, hole := range g.Holes {
+		if hole.Player == g.CurrentPlayer && !hole.Winhole && len(hole.Stones) > 0 {
+			moves = append(moves, idx)
+		}
+	}
+	if len(moves) == 0 {
+		return -1
+	}
+	return moves[rand.Intn(len(moves))]
+}
+
+// NewBotRoom creates a room seated entirely with bots.
+func NewBotRoom(size int, teams bool, rules []Rule, stones []StoneType) (*Room, error) {
+	room, err := NewRoom("sim", size, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := room.SetTeams(teams); err != nil {
+		return nil, err
+	}
+	if rules != nil {
+		room.Rules = rules
+	}
+	if stones != nil {
+		if err := room.SetStones(stones); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < room.Board.NumPlayers; i++ {
+		room.Players = append(room.Players, &Player{Name: fmt.Sprintf("Bot %d", i+1)})
+	}
+	// Report what the rules ask for, bot games happen too fast for a time window to mean anything
+	room.Safety.MaxPerGame = 0
+	room.Safety.MaxPerWindow = 0
+	return room, nil
+}
+
+// Simulate plays bot games, stopping early with the context's error if it is
+// done before they are all played.
+func Simulate(ctx context.Context, cfg SimulationConfig) (*SimulationReport, error) {
+	if cfg.Games <= 0 {
+		cfg.Games = SIM_DEFAULT_GAMES
+	}
+	if cfg.Games > SIM_MAX_GAMES {
+		return nil, fmt.Errorf("can not simulate more than %d games", SIM_MAX_GAMES)
+	}
+	if cfg.Rules == nil {
+		cfg.Rules = NewDefaultRules()
+	}
+
+	// Check the variant is valid before playing anything
+	probe, err := NewBotRoom(cfg.Size, cfg.Teams, cfg.Rules, cfg.Stones)
+	if err != nil {
+		return nil, err
+	}
+	numPlayers := probe.Board.NumPlayers
+
+	report := &SimulationReport{Games: cfg.Games, Wins: make([]int, numPlayers)}
+	lengths := []int{}
+	counts := make([][][]int, len(cfg.Rules)) // rule -> player -> per game
+	for ridx := range cfg.Rules {
+		report.Rules = append(report.Rules, RuleStats{Rule: ridx, Text: cfg.Rules[ridx].Text})
+		counts[ridx] = make([][]int, numPlayers)
+	}
+
+	for game := 0; game < cfg.Games; game++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		room, err := NewBotRoom(cfg.Size, cfg.Teams, cfg.Rules, cfg.Stones)
+		if err != nil {
+			return nil, err
+		}
+		for moves := 0; !room.Board.Finished; moves++ {
+			if moves >= SIM_MAX_MOVES {
+				return nil, errors.New("game did not finish within move limit")
+			}
+			idx := RandomMove(room.Board)
+			if idx < 0 {
+				return nil, errors.New("current player has no legal move")
+			}
+			err := room.DoAction(&Action{Player: room.Players[room.Board.CurrentPlayer].Name, Index: idx})
+			if err != nil {
+				return nil, err
+			}
+		}
+		lengths = append(lengths, room.Board.Turn)
+
+		perGame := make([][]int, len(cfg.Rules))
+		for ridx := range perGame {
+			perGame[ridx] = make([]int, numPlayers)
+		}
+		tied := false
+		for _, entry := range room.History {
+			for _, o := range entry.Outcomes {
+				for _, p := range o.Players {
+					perGame[o.Rule][p] += o.Count
+				}
+				report.Rules[o.Rule].Fired += o.Count
+			}
+			for _, ev := range entry.Events {
+				if ev.Victory == 1 {
+					report.Wins[ev.Player] += 1
+				} else if ev.Victory == 2 {
+					tied = true
+				}
+			}
+		}
+		if tied {
+			report.Ties += 1
+		}
+		for ridx := range perGame {
+			fired := false
+			for p, n := range perGame[ridx] {
+				counts[ridx][p] = append(counts[ridx][p], n)
+				fired = fired || n > 0
+			}
+			if fired {
+				report.Rules[ridx].Games += 1
+			}
+		}
+	}
+
+	report.GameLength = NewDistribution(lengths)
+	for ridx := range report.Rules {
+		for p := 0; p < numPlayers; p++ {
+			report.Rules[ridx].Players = append(report.Rules[ridx].Players, NewDistribution(counts[ridx][p]))
+		}
+	}
+	return report, nil
+}
+
+func (s *SimulationReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "%d games, moves per game: mean %.1f, min %d, p50 %d, p90 %d, max %d\n",
+		s.Games, s.GameLength.Mean, s.GameLength.Min, s.GameLength.P50, s.GameLength.P90, s.GameLength.Max)
+	for p, wins := range s.Wins {
+		fmt.Fprintf(w, "Bot %d won %d games\n", p+1, wins)
+	}
+	fmt.Fprintf(w, "%d tied games\n", s.Ties)
+	for _, rule := range s.Rules {
+		fmt.Fprintf(w, "\nrule %d %q: %d prompts, fired in %d games\n", rule.Rule, rule.Text, rule.Fired, rule.Games)
+		for p, d := range rule.Players {
+			fmt.Fprintf(w, "  Bot %d per game: mean %.2f, min %d, p50 %d, p90 %d, max %d\n", p+1, d.Mean, d.Min, d.P50, d.P90, d.Max)
+		}
+	}
+}
+
+// RunSimulateCommand implements the "simulate" subcommand of the server binary.
+func RunSimulateCommand(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	size := fs.Int("size", 2, "board size")
+	teams := fs.Bool("teams", false, "play in two teams, needs a board of at least 4")
+	games := fs.Int("games", SIM_DEFAULT_GAMES, "number of games to play")
+	rulesFile := fs.String("rules", "", "JSON file with a list of rules, defaults to the default rules")
+	seed := fs.Int64("seed", 0, "random seed, defaults to the current time")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *seed != 0 {
+		rand.Seed(*seed)
+	} else {
+		rand.Seed(time.Now().UnixNano())
+	}
+
+	cfg := SimulationConfig{Size: *size, Teams: *teams, Games: *games}
+	if *rulesFile != "" {
+		data, err := ioutil.ReadFile(*rulesFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &cfg.Rules); err != nil {
+			return err
+		}
+		for i := range cfg.Rules {
+			if cfg.Rules[i], err = cfg.Rules[i].Clean(); err != nil {
+				return fmt.Errorf("rule %d in %s: %s", i, *rulesFile, err)
+			}
+		}
+	}
+
+	report, err := Simulate(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	report.Print(os.Stdout)
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAPISimulate(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		games int
+		want  int   // games played
+		err   error // nil for any error when no games are played
+	}{
+		{"default", context.Background(), 0, SIM_MAX_API_GAMES, nil},
+		{"some", context.Background(), 3, 3, nil},
+		{"over the cap", context.Background(), SIM_MAX_API_GAMES + 1, 0, nil},
+		{"cancelled", cancelled, 3, 0, context.Canceled},
+	}
+	api := &API{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report, err := api.Simulate(test.ctx, SimulateRequest{Size: 2, Games: test.games})
+			if test.want == 0 {
+				if err == nil || (test.err != nil && !errors.Is(err, test.err)) {
+					t.Errorf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Games != test.want {
+				t.Errorf("played %d games, want %d", report.Games, test.want)
+			}
+		})
+	}
+}
+
+func TestDefaultDecksAreCopies(t *testing.T) {
+	first, second := LoadDefaultDecks(), LoadDefaultDecks()
+	if len(first) == 0 {
+		t.Fatal("no default decks")
+	}
+	for name, deck := range first {
+		for range deck.Cards {
+			deck.Draw(DECK_ANY_LEVEL)
+		}
+		if remaining := second[name].Info().Remaining; remaining != len(deck.Cards) {
+			t.Errorf("drawing from one %s deck left %d of %d cards in another", name, remaining, len(deck.Cards))
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+const (
+	SSH_HANDSHAKE_TIMEOUT = 10 * time.Second
+	SSH_IDLE_TIMEOUT      = time.Minute      // Until a connection opens a session or a session starts playing
+	SSH_PLAY_TIMEOUT      = 30 * time.Minute // Between commands while playing
+	SSH_QUEUE_SIZE        = 64               // Messages a session can fall behind by before it's disconnected
+)
+
+var ErrConnClosed = errors.New("connection closed")
+
+// sshConns counts open SSH connections, which also count against max_conns.
+var sshConns int64
+
+// LocalConn is a connection to a room from within the server. Messages are
+// queued for the reader, and one that falls too far behind is disconnected
+// like a websocket client whose writes fail.
+type LocalConn struct {
+	messages chan []byte
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func NewLocalConn() *LocalConn {
+	return &LocalConn{messages: make(chan []byte, SSH_QUEUE_SIZE), closed: make(chan struct{})}
+}
+
+func (c *LocalConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	// Checked first, as a select with both ready picks either
+	select {
+	case <-c.closed:
+		return ErrConnClosed
+	default:
+	}
+	select {
+	case c.messages <- data:
+		return nil
+	default:
+		return errors.New("connection is not keeping up")
+	}
+}
+
+// ReadJSON waits for the next message, failing once the connection closes
+// and the messages queued before it have been read.
+func (c *LocalConn) ReadJSON(v interface{}) error {
+	select {
+	case data := <-c.messages:
+		return json.Unmarshal(data, v)
+	case <-c.closed:
+		select {
+		case data := <-c.messages:
+			return json.Unmarshal(data, v)
+		default:
+			return ErrConnClosed
+		}
+	}
+}
+
+func (c *LocalConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+// localStream detaches its connection from the room when closed.
+type localStream struct {
+	*LocalConn
+	detach func()
+}
+
+func (s *localStream) Close() error {
+	s.detach()
+	return nil
+}
+
+// LocalClient plays through the API directly, so SSH sessions share the
+// server's rooms with browsers. It is rate limited like the endpoints.
+type LocalClient struct {
+	api    *API
+	limits *RateLimits
+	ip     string
+	log    *Logger
+}
+
+func (c *LocalClient) allow(name string, code string, player string) error {
+	key := ""
+	if player != "" {
+		key = NormalizeCode(code) + "/" + player
+	}
+	if ok, wait := c.limits.Allow(name, c.ip, key); !ok {
+		return fmt.Errorf("too many requests, try again in %.0f seconds", wait.Seconds()+0.5)
+	}
+	return nil
+}
+
+func decodeRoom(state json.RawMessage, err error) (*ClientRoom, error) {
+	if err != nil {
+		return nil, err
+	}
+	var room ClientRoom
+	return &room, json.Unmarshal(state, &room)
+}
+
+func (c *LocalClient) Create(size int) (string, error) {
+	if err := c.allow("create", "", ""); err != nil {
+		return "", err
+	}
+	res, err := c.api.CreateRoom(CreateRoomRequest{Size: size, WordCode: true})
+	if err != nil {
+		return "", err
+	}
+	return res.Code, nil
+}
+
+func (c *LocalClient) Join(code string, name string) (*ClientRoom, error) {
+	if err := c.allow("join", code, name); err != nil {
+		return nil, err
+	}
+	state, _, err := c.api.JoinRoom(code, JoinRequest{Name: name})
+	return decodeRoom(state, err)
+}
+
+func (c *LocalClient) State(code string) (*ClientRoom, error) {
+	return decodeRoom(c.api.GetRoom(code))
+}
+
+func (c *LocalClient) Move(code string, name string, index int) error {
+	if err := c.allow("input", code, name); err != nil {
+		return err
+	}
+	return c.api.Move(code, MoveRequest{name, index}, c.log.With("room", NormalizeCode(code), "player", name))
+}
+
+func (c *LocalClient) Reset(code string, name string) error {
+	if err := c.allow("input", code, name); err != nil {
+		return err
+	}
+	return c.api.Reset(code)
+}
+
+func (c *LocalClient) History(code string, offset int) (*HistoryResponse, error) {
+	return c.api.History(code, offset, HISTORY_MAX_PAGE_SIZE)
+}
+
+func (c *LocalClient) Chat(code string, name string, text string) error {
+	if err := c.allow("chat", code, name); err != nil {
+		return err
+	}
+	_, err := c.api.Chat(code, ChatRequest{Name: name, Text: text})
+	return err
+}
+
+func (c *LocalClient) Stream(code string, name string) (RoomStream, error) {
+	conn := NewLocalConn()
+	detach, err := c.api.Attach(code, name, conn)
+	if err != nil {
+		return nil, err
+	}
+	return &localStream{conn, detach}, nil
+}
+
+// LoadHostKey reads the SSH host key from a file, generating and saving one
+// if the file doesn't exist. Without a file the key lasts until a restart,
+// and clients will warn that it changed.
+func LoadHostKey(path string) (ssh.Signer, error) {
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			return ssh.ParsePrivateKey(data)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		block, err := ssh.MarshalPrivateKey(key, "drunkala host key")
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			return nil, err
+		}
+		Log.Info("generated ssh host key", "file", path)
+	} else {
+		Log.Warn("no ssh host key file configured, using a key that changes on every restart")
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// SSHServer lets players join rooms from a terminal with plain ssh, playing
+// alongside players in browsers. Anyone may connect and the SSH user name is
+// the player's name, which is no more trust than the web client has.
+type SSHServer struct {
+	api    *API
+	limits *RateLimits
+	config *ssh.ServerConfig
+}
+
+func NewSSHServer(api *API, limits *RateLimits, hostKey ssh.Signer) *SSHServer {
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(hostKey)
+	return &SSHServer{api: api, limits: limits, config: cfg}
+}
+
+// Serve accepts connections until the listener is closed.
+func (s *SSHServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *SSHServer) handle(conn net.Conn) {
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	log := Log.With("ssh", conn.RemoteAddr().String())
+	if ok, _ := s.limits.Allow("ssh", ip, ""); !ok {
+		log.Debug("ssh connection rate limited")
+		conn.Close()
+		return
+	}
+	if !AcquireConn() {
+		log.Debug("ssh connection refused, server is full")
+		conn.Close()
+		return
+	}
+	defer ReleaseConn()
+	atomic.AddInt64(&sshConns, 1)
+	defer atomic.AddInt64(&sshConns, -1)
+
+	// Clients that never finish the handshake don't get to hold a connection
+	conn.SetDeadline(time.Now().Add(SSH_HANDSHAKE_TIMEOUT))
+	sconn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		log.Debug("ssh handshake failed", "error", err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	defer sconn.Close()
+	go ssh.DiscardRequests(requests)
+
+	// Connections without a session open are closed after a while
+	var mu sync.Mutex
+	sessions := 0
+	idle := time.AfterFunc(SSH_IDLE_TIMEOUT, func() {
+		log.Debug("closing idle ssh connection")
+		sconn.Close()
+	})
+	defer idle.Stop()
+
+	for ch := range channels {
+		if ch.ChannelType() != "session" {
+			ch.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := ch.Accept()
+		if err != nil {
+			log.Warn("could not accept ssh session", "error", err)
+			continue
+		}
+		client := &LocalClient{api: s.api, limits: s.limits, ip: ip, log: log}
+		mu.Lock()
+		sessions += 1
+		idle.Stop()
+		mu.Unlock()
+		go func() {
+			s.session(sconn.User(), client, channel, requests)
+			mu.Lock()
+			if sessions -= 1; sessions == 0 {
+				idle.Reset(SSH_IDLE_TIMEOUT)
+			}
+			mu.Unlock()
+		}()
+	}
+}
+
+// session waits for the client to ask for a shell, or to run a command which
+// is taken as a room code, and then plays in a terminal on the channel. A
+// session that takes too long to get to playing, or then goes quiet, is closed.
+func (s *SSHServer) session(name string, client *LocalClient, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	terminal := term.NewTerminal(channel, "")
+	idle := time.AfterFunc(SSH_IDLE_TIMEOUT, func() {
+		fmt.Fprintf(terminal, "\nDisconnected for being idle\n")
+		channel.Close()
+	})
+	defer idle.Stop()
+	start := make(chan string, 1)
+	go func() {
+		started := false
+		for req := range requests {
+			ok := false
+			switch req.Type {
+			case "pty-req":
+				var pty struct {
+					Term          string
+					Columns, Rows uint32
+					Width, Height uint32
+					Modes         string
+				}
+				if ssh.Unmarshal(req.Payload, &pty) == nil {
+					setSize(terminal, pty.Columns, pty.Rows)
+					ok = true
+				}
+			case "window-change":
+				var size struct{ Columns, Rows, Width, Height uint32 }
+				if ssh.Unmarshal(req.Payload, &size) == nil {
+					setSize(terminal, size.Columns, size.Rows)
+					ok = true
+				}
+			case "shell", "exec":
+				var command struct{ Command string }
+				ssh.Unmarshal(req.Payload, &command)
+				ok = !started
+				if ok {
+					started = true
+					start <- strings.TrimSpace(command.Command)
+				}
+			}
+			if req.WantReply {
+				req.Reply(ok, nil)
+			}
+		}
+		if !started {
+			close(start)
+		}
+	}()
+
+	code, ok := <-start
+	if !ok {
+		return
+	}
+	status := 0
+	if err := s.play(terminal, client, name, code, idle); err != nil {
+		fmt.Fprintf(terminal, "%s\n", err)
+		status = 1
+	}
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+}
+
+func (s *SSHServer) play(terminal *term.Terminal, client *LocalClient, name string, code string, idle *time.Timer) error {
+	fmt.Fprintf(terminal, "Welcome to drunkala, %s!\n", name)
+	if code == "" {
+		terminal.SetPrompt("Room code, or enter to start a new room: ")
+		line, err := terminal.ReadLine()
+		if err != nil {
+			return nil
+		}
+		code = strings.TrimSpace(line)
+	}
+	if code == "" {
+		terminal.SetPrompt("Number of players, 2, 4 or 6: ")
+		line, err := terminal.ReadLine()
+		if err != nil {
+			return nil
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line))
+		if err != nil {
+			size = 2
+		}
+		if code, err = client.Create(size); err != nil {
+			return err
+		}
+	}
+	terminal.SetPrompt("drunkala> ")
+	idle.Reset(SSH_PLAY_TIMEOUT)
+	return NewTerminal(client, &terminalReader{terminal: terminal, idle: idle}, terminal).Play(code, name)
+}
+
+// setSize resizes a terminal, ignoring the zero size clients send when they
+// don't know their own.
+func setSize(terminal *term.Terminal, columns uint32, rows uint32) {
+	if columns > 0 && rows > 0 {
+		terminal.SetSize(int(columns), int(rows))
+	}
+}
+
+// terminalReader reads the lines of a terminal, which echoes and edits them.
+// Every line puts off the idle timeout.
+type terminalReader struct {
+	terminal *term.Terminal
+	idle     *time.Timer
+	buf      []byte
+}
+
+func (r *terminalReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		line, err := r.terminal.ReadLine()
+		if err != nil {
+			return 0, io.EOF
+		}
+		r.idle.Reset(SSH_PLAY_TIMEOUT)
+		r.buf = []byte(line + "\n")
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// ServeSSH starts serving SSH sessions if an address is configured,
+// returning the listener so it can be closed on shutdown.
+func ServeSSH(cfg *Config, api *API, limits *RateLimits) (net.Listener, error) {
+	if cfg.SSHListen == "" {
+		return nil, nil
+	}
+	key, err := LoadHostKey(cfg.SSHHostKey)
+	if err != nil {
+		return nil, fmt.Errorf("ssh host key: %s", err)
+	}
+	l, err := net.Listen("tcp", cfg.SSHListen)
+	if err != nil {
+		return nil, err
+	}
+	srv := NewSSHServer(api, limits, key)
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, net.ErrClosed) {
+			Log.Error("ssh server failed", "error", err)
+		}
+	}()
+	Log.Info("ssh server starting", "listen", cfg.SSHListen)
+	return l, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLocalConn(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes int
+		close  bool
+		// results of the writes that failed, and how many messages can be
+		// read back before reading fails
+		failed error
+		reads  int
+	}{
+		{"empty", 0, false, nil, 0},
+		{"queued", 3, false, nil, 3},
+		{"full", SSH_QUEUE_SIZE, false, nil, SSH_QUEUE_SIZE},
+		{"falling behind", SSH_QUEUE_SIZE + 2, false, errors.New("connection is not keeping up"), SSH_QUEUE_SIZE},
+		{"closed after writes", 3, true, nil, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := NewLocalConn()
+			failures := 0
+			for i := 0; i < test.writes; i++ {
+				if err := conn.WriteJSON(i); err != nil {
+					failures += 1
+					if test.failed == nil || err.Error() != test.failed.Error() {
+						t.Fatalf("write %d: %v", i, err)
+					}
+				}
+			}
+			if want := test.writes - test.reads; test.failed != nil && failures != want {
+				t.Errorf("%d writes failed, want %d", failures, want)
+			}
+			if test.close || test.reads == 0 {
+				conn.Close()
+			}
+
+			for i := 0; i < test.reads; i++ {
+				var n int
+				if err := conn.ReadJSON(&n); err != nil || n != i {
+					t.Fatalf("read %d got %d, %v", i, n, err)
+				}
+			}
+			if test.close || test.reads == 0 {
+				var n int
+				if err := conn.ReadJSON(&n); err != ErrConnClosed {
+					t.Errorf("read after the queue drained got %v, want %v", err, ErrConnClosed)
+				}
+				if err := conn.WriteJSON(0); err != ErrConnClosed {
+					t.Errorf("write after closing got %v, want %v", err, ErrConnClosed)
+				}
+				if err := conn.Close(); err != nil {
+					t.Errorf("closing twice: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestAttach(t *testing.T) {
+	tests := []struct {
+		name   string
+		closed bool
+		player string
+		conns  int // already attached for the player
+		err    error
+	}{
+		{"attached", false, "ada", 0, nil},
+		{"unknown player", false, "bob", 0, NotFound("player bob")},
+		{"too many connections", false, "ada", MAX_PLAYER_CONNS, ErrTooManyPlayerWS},
+		{"shutting down", true, "ada", 0, ErrShuttingDown},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room, err := NewRoom("test", 2, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			player := &Player{Name: "ada", Conns: map[Conn]bool{}}
+			for i := 0; i < test.conns; i++ {
+				player.Conns[NewLocalConn()] = true
+			}
+			room.Players = append(room.Players, player)
+			api := &API{Rooms: &LockedRooms{Rooms: map[string]*Room{"test": room}, Closed: test.closed}}
+
+			before := atomic.LoadInt64(&openConns)
+			conn := NewLocalConn()
+			detach, err := api.Attach("test", test.player, conn)
+			if test.err != nil {
+				if err == nil || err.Error() != test.err.Error() {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				if atomic.LoadInt64(&openConns) != before {
+					t.Errorf("refused connection was counted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !player.Conns[conn] || atomic.LoadInt64(&openConns) != before+1 {
+				t.Fatalf("connection not registered")
+			}
+			detach()
+			detach()
+			if player.Conns[conn] || atomic.LoadInt64(&openConns) != before {
+				t.Errorf("connection still registered after detaching")
+			}
+			if err := conn.WriteJSON(0); err != ErrConnClosed {
+				t.Errorf("detached connection still open: %v", err)
+			}
+		})
+	}
+}
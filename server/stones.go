@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	STONE_PLAIN = ""
+	STONE_WILD  = "wild" // Sowing it as the last stone gives the player another turn
+	STONE_BOMB  = "bomb" // Capturing it emits a Bomb event for the capturer
+)
+
+type StoneType struct {
+	Stone  int    `json:"stone"`
+	Colour string `json:"colour"`
+	Label  string `json:"label"`
+	Effect string `json:"effect"`
+}
+
+func NewDefaultStones() []StoneType {
+	return []StoneType{
+		StoneType{
+			Stone:  0,
+			Colour: "gold",
+			Label:  "confession",
+		},
+	}
+}
+
+// NumStones is the number of stones in play, which are numbered from 0.
+func (g *GameBoard) NumStones() int {
+	n := 0
+	for _, hole := range g.Holes {
+		n += len(hole.Stones)
+	}
+	return n
+}
+
+func (r *Room) SetStones(stones []StoneType) error {
+	seen := map[int]bool{}
+	stones = append([]StoneType{}, stones...)
+	for i := range stones {
+		st := &stones[i]
+		var err error
+		if st.Label, err = checkText("stone label", st.Label, MAX_LABEL_LENGTH); err != nil {
+			return err
+		}
+		if st.Colour, err = checkText("stone colour", st.Colour, MAX_LABEL_LENGTH); err != nil {
+			return err
+		}
+		if st.Stone < 0 || st.Stone >= r.Board.NumStones() {
+			return fmt.Errorf("stone %d does not exist", st.Stone)
+		}
+		if seen[st.Stone] {
+			return fmt.Errorf("stone %d is configured twice", st.Stone)
+		}
+		seen[st.Stone] = true
+		if st.Effect != STONE_PLAIN && st.Effect != STONE_WILD && st.Effect != STONE_BOMB {
+			return errors.New("unknown stone effect " + st.Effect)
+		}
+	}
+	r.Stones = stones
+	return nil
+}
+
+func (r *Room) StoneType(stone int) (StoneType, bool) {
+	for _, st := range r.Stones {
+		if st.Stone == stone {
+			return st, true
+		}
+	}
+	return StoneType{}, false
+}
+
+func (r *Room) StoneHasEffect(stone int, effect string) bool {
+	st, ok := r.StoneType(stone)
+	return ok && st.Effect == effect
+}
+
+func (r *Room) HasLabelledStone(stones []int, label string) bool {
+	for _, stone := range stones {
+		if st, ok := r.StoneType(stone); ok && st.Label == label {
+			return true
+		}
+	}
+	return false
+}
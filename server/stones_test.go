@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStoneEffects(t *testing.T) {
+	// Hole 0 holds stones 0 and 1 and plays into empty hole 2, capturing
+	// stones 30 to 33 from hole 10. Hole 5 holds stones 20 to 22 and plays
+	// into hole 8.
+	capture := []int{2, 4, 0, 4, 4, 4, 0, 4, 4, 4, 4, 4, 4, 0}
+	sow := []int{4, 4, 4, 4, 4, 3, 0, 4, 4, 4, 4, 4, 4, 0}
+	wild := func(stones ...int) []StoneType {
+		types := []StoneType{}
+		for _, stone := range stones {
+			types = append(types, StoneType{Stone: stone, Effect: STONE_WILD})
+		}
+		return types
+	}
+	bomb := func(stones ...int) []StoneType {
+		types := []StoneType{}
+		for _, stone := range stones {
+			types = append(types, StoneType{Stone: stone, Effect: STONE_BOMB})
+		}
+		return types
+	}
+
+	tests := []struct {
+		name    string
+		holes   []int
+		stones  []StoneType
+		hole    int
+		repeats []int
+		bombs   []int
+		next    int
+	}{
+		{"plain stones", sow, nil, 5, []int{}, []int{}, 1},
+		{"wild last stone", sow, wild(20, 21, 22), 5, []int{1}, []int{}, 0},
+		{"wild stone elsewhere", sow, wild(0), 5, []int{}, []int{}, 1},
+		{"capture without bombs", capture, bomb(26), 0, []int{}, []int{}, 1},
+		{"one bomb captured", capture, bomb(31), 0, []int{}, []int{1}, 1},
+		{"two bombs captured", capture, bomb(0, 1, 32), 0, []int{}, []int{2}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room := newTestRoom(t, 2, test.holes)
+			if err := room.SetStones(test.stones); err != nil {
+				t.Fatal(err)
+			}
+			evs := play(t, room, test.hole)
+			if got := eventValues(evs, func(ev Event) int { return ev.Repeat }); !reflect.DeepEqual(got, test.repeats) {
+				t.Errorf("repeats %v, want %v", got, test.repeats)
+			}
+			if got := eventValues(evs, func(ev Event) int { return ev.Bomb }); !reflect.DeepEqual(got, test.bombs) {
+				t.Errorf("bombs %v, want %v", got, test.bombs)
+			}
+			if room.Board.CurrentPlayer != test.next {
+				t.Errorf("player %d to move, want %d", room.Board.CurrentPlayer, test.next)
+			}
+		})
+	}
+}
+
+func TestSetStones(t *testing.T) {
+	tests := []struct {
+		name   string
+		stones []StoneType
+		ok     bool
+	}{
+		{"none", []StoneType{}, true},
+		{"wild and bomb", []StoneType{{Stone: 0, Effect: STONE_WILD}, {Stone: 1, Effect: STONE_BOMB}}, true},
+		{"unknown effect", []StoneType{{Stone: 0, Effect: "glitter"}}, false},
+		{"missing stone", []StoneType{{Stone: 48}}, false},
+		{"negative stone", []StoneType{{Stone: -1}}, false},
+		{"stone twice", []StoneType{{Stone: 3}, {Stone: 3, Effect: STONE_BOMB}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room, err := NewRoom("TEST", 2, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := room.SetStones(test.stones); (err == nil) != test.ok {
+				t.Errorf("got error %v, want ok %v", err, test.ok)
+			}
+		})
+	}
+}
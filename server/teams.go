@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+)
+
+const (
+	HOLES_PER_PLAYER  = 6
+	STONES_PER_HOLE   = 4
+	BOARD_RADIUS      = 2.4 // Radius of the ring of holes on boards with more than two players
+	BOARD_ASPECT      = 2.0 // Horizontal stretch of the ring to fit the canvas
+	MIN_TEAMS_PLAYERS = 4
+)
+
+// NewBoard creates a board for an even number of players. Each player's holes
+// face the holes of the player next to them in turn order, so on a teams
+// board captures are always made from the other team.
+func NewBoard(numPlayers int) (*GameBoard, error) {
+	if numPlayers == 2 {
+		return NewTwoPlayerBoard(), nil
+	}
+	if numPlayers < 2 || numPlayers > 6 || numPlayers%2 != 0 {
+		return nil, errors.New("Invalid board size")
+	}
+
+	b := &GameBoard{
+		Holes:         []*Hole{},
+		NumPlayers:    numPlayers,
+		CurrentPlayer: rand.Intn(numPlayers),
+	}
+	newStones := ShuffledStoneProvider(numPlayers*HOLES_PER_PLAYER*STONES_PER_HOLE, STONES_PER_HOLE)
+	slots := HOLES_PER_PLAYER + 1
+	vertex := func(i int) (float64, float64) {
+		angle := -math.Pi/2 - 2*math.Pi*float64(i)/float64(numPlayers)
+		return BOARD_RADIUS * math.Cos(angle), BOARD_RADIUS * math.Sin(angle)
+	}
+
+	for p := 0; p < numPlayers; p++ {
+		x0, y0 := vertex(p)
+		x1, y1 := vertex(p + 1)
+		opponent := p ^ 1
+		for k := 0; k < slots; k++ {
+			t := (float64(k) + 0.5) / float64(slots)
+			hole := &Hole{
+				X:               BOARD_ASPECT * (x0 + t*(x1-x0)),
+				Y:               y0 + t*(y1-y0),
+				OpposingHoleIdx: opponent*slots + (HOLES_PER_PLAYER - 1 - k),
+				Player:          p,
+				Winhole:         false,
+				Stones:          []int{},
+			}
+			if k == HOLES_PER_PLAYER {
+				hole.OpposingHoleIdx = -1
+				hole.Winhole = true
+			} else {
+				hole.Stones = newStones()
+			}
+			b.Holes = append(b.Holes, hole)
+		}
+	}
+	return b, nil
+}
+
+// SetTeams splits the players into two teams that alternate turns.
+func (g *GameBoard) SetTeams(enabled bool) error {
+	if !enabled {
+		g.Teams = nil
+		return nil
+	}
+	if g.NumPlayers < MIN_TEAMS_PLAYERS {
+		return errors.New("teams need a board with at least 4 players")
+	}
+	g.Teams = make([]int, g.NumPlayers)
+	for p := range g.Teams {
+		g.Teams[p] = p % 2
+	}
+	return nil
+}
+
+// Side is the team of a player in teams mode, or the player themselves.
+func (g *GameBoard) Side(p int) int {
+	if g.Teams != nil {
+		return g.Teams[p]
+	}
+	return p
+}
+
+func (g *GameBoard) NumSides() int {
+	if g.Teams != nil {
+		return 2
+	}
+	return g.NumPlayers
+}
+
+func (g *GameBoard) SideMembers(side int) []int {
+	members := []int{}
+	for p := 0; p < g.NumPlayers; p++ {
+		if g.Side(p) == side {
+			members = append(members, p)
+		}
+	}
+	return members
+}
+
+// SideScores combines the scores of each team, or is each player's score.
+func (g *GameBoard) SideScores() []int {
+	scores := make([]int, g.NumSides())
+	for p, score := range g.Scores() {
+		scores[g.Side(p)] += score
+	}
+	return scores
+}
+
+// Opponents are the players not on the same side as the given player.
+func (g *GameBoard) Opponents(p int) []int {
+	opponents := []int{}
+	for i := 0; i < g.NumPlayers; i++ {
+		if g.Side(i) != g.Side(p) {
+			opponents = append(opponents, i)
+		}
+	}
+	return opponents
+}
+
+func (r *Room) SetTeams(enabled bool) error {
+	if err := r.Board.SetTeams(enabled); err != nil {
+		return err
+	}
+	r.TeamsMode = enabled
+	return nil
+}
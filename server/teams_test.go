@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewBoard(t *testing.T) {
+	tests := []struct {
+		players int
+		ok      bool
+	}{
+		{0, false}, {1, false}, {2, true}, {3, false}, {4, true}, {5, false}, {6, true}, {8, false},
+	}
+	for _, test := range tests {
+		board, err := NewBoard(test.players)
+		if (err == nil) != test.ok {
+			t.Errorf("%d players: got error %v, want ok %v", test.players, err, test.ok)
+		}
+		if err != nil {
+			continue
+		}
+		if len(board.Holes) != test.players*(HOLES_PER_PLAYER+1) {
+			t.Errorf("%d players: %d holes", test.players, len(board.Holes))
+		}
+		if board.NumStones() != test.players*HOLES_PER_PLAYER*STONES_PER_HOLE {
+			t.Errorf("%d players: %d stones", test.players, board.NumStones())
+		}
+		for idx, hole := range board.Holes {
+			if hole.Winhole {
+				if hole.OpposingHoleIdx != -1 {
+					t.Errorf("%d players: store %d faces hole %d", test.players, idx, hole.OpposingHoleIdx)
+				}
+				continue
+			}
+			// Holes face the next player in turn order, 0 and 1, 2 and 3...
+			opposite := board.Holes[hole.OpposingHoleIdx]
+			if opposite.Player != hole.Player^1 || opposite.Winhole || opposite.OpposingHoleIdx != idx {
+				t.Errorf("%d players: hole %d of player %d faces hole %d of player %d", test.players, idx, hole.Player, hole.OpposingHoleIdx, opposite.Player)
+			}
+		}
+	}
+}
+
+func TestTeams(t *testing.T) {
+	tests := []struct {
+		name      string
+		players   int
+		teams     bool
+		scores    []int // store of each player
+		sides     []int
+		opponents []int // of player 0
+		winner    int
+	}{
+		{"two players", 2, false, []int{5, 3}, []int{5, 3}, []int{1}, 0},
+		{"four players", 4, false, []int{1, 2, 4, 3}, []int{1, 2, 4, 3}, []int{1, 2, 3}, 2},
+		{"two teams of two", 4, true, []int{1, 2, 4, 3}, []int{5, 5}, []int{1, 3}, -1},
+		{"team wins on its total", 4, true, []int{1, 6, 1, 3}, []int{2, 9}, []int{1, 3}, 1},
+		{"two teams of three", 6, true, []int{3, 0, 3, 0, 3, 8}, []int{9, 8}, []int{1, 3, 5}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			board, err := NewBoard(test.players)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := board.SetTeams(test.teams); err != nil {
+				t.Fatal(err)
+			}
+			for p, score := range test.scores {
+				board.Holes[board.PlayerWinholeIdx(p)].Stones = make([]int, score)
+			}
+			if got := board.SideScores(); !reflect.DeepEqual(got, test.sides) {
+				t.Errorf("side scores %v, want %v", got, test.sides)
+			}
+			if got := board.Opponents(0); !reflect.DeepEqual(got, test.opponents) {
+				t.Errorf("opponents %v, want %v", got, test.opponents)
+			}
+			if winner, _ := Leader(board.SideScores()); winner != test.winner {
+				t.Errorf("winner %d, want %d", winner, test.winner)
+			}
+		})
+	}
+
+	board, _ := NewBoard(2)
+	if err := board.SetTeams(true); err == nil {
+		t.Error("teams allowed on a two player board")
+	}
+}
+
+func TestTeamVictory(t *testing.T) {
+	// Player 0 ends the game by playing their last stone into their store,
+	// and team 0 wins on its combined stores though player 1 has the most.
+	holes := make([]int, 4*(HOLES_PER_PLAYER+1))
+	for idx := range holes {
+		holes[idx] = 1
+	}
+	for idx := 0; idx < HOLES_PER_PLAYER; idx++ {
+		holes[idx] = 0
+	}
+	holes[HOLES_PER_PLAYER-1] = 1
+	room := newTestRoom(t, 4, holes)
+	if err := room.SetTeams(true); err != nil {
+		t.Fatal(err)
+	}
+	stores := []int{10, 12, 10, 0}
+	for p, n := range stores {
+		room.Board.Holes[room.Board.PlayerWinholeIdx(p)].Stones = make([]int, n)
+	}
+
+	evs := play(t, room, HOLES_PER_PLAYER-1)
+	if !room.Board.Finished {
+		t.Fatal("game did not finish")
+	}
+	victories := map[int]int{}
+	for _, ev := range evs {
+		if ev.Victory != 0 {
+			victories[ev.Player] = ev.Victory
+		}
+	}
+	// Team 0 has 10+1+10+6 stones, team 1 has 12+6+0+6
+	want := map[int]int{0: 1, 1: -3, 2: 1, 3: -3}
+	if !reflect.DeepEqual(victories, want) {
+		t.Errorf("victories %v, want %v", victories, want)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TERMINAL_BACKLOG   = 5 // History entries shown on joining a room
+	TERMINAL_RECONNECT = 30 * time.Second
+)
+
+const TERMINAL_HELP = `Commands:
+  1-6         play one of your holes, numbered left to right
+  n           start a new game once this one has finished
+  say <text>  chat to the room
+  b           draw the board again
+  q           quit
+`
+
+// Terminal is an interactive text client. It draws the board whenever the
+// room changes, prints the history as it happens and reads a command a line.
+type Terminal struct {
+	sync.Mutex
+	client GameClient
+	in     io.Reader
+	out    io.Writer
+	code   string
+	name   string
+	room   *ClientRoom
+	seen   int // history entries printed so far
+	stream RoomStream
+	done   chan struct{}
+}
+
+func NewTerminal(client GameClient, in io.Reader, out io.Writer) *Terminal {
+	return &Terminal{client: client, in: in, out: out, done: make(chan struct{})}
+}
+
+// Play joins a room and plays until the input ends or the player quits.
+func (t *Terminal) Play(code string, name string) error {
+	t.name = strings.TrimSpace(name)
+	room, err := t.client.Join(code, t.name)
+	if err != nil {
+		return err
+	}
+	t.code = room.Code
+	t.seen = room.HistoryLen - TERMINAL_BACKLOG
+	if t.seen < 0 {
+		t.seen = 0
+	}
+	fmt.Fprintf(t.out, "Joined room %s as %s, others can join with the same code.\n%s\n", t.code, t.name, TERMINAL_HELP)
+	t.refresh()
+	go t.follow()
+	defer t.quit()
+
+	scanner := bufio.NewScanner(t.in)
+	for scanner.Scan() {
+		if !t.command(strings.TrimSpace(scanner.Text())) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func (t *Terminal) quit() {
+	t.Lock()
+	defer t.Unlock()
+	close(t.done)
+	if t.stream != nil {
+		t.stream.Close()
+	}
+}
+
+func (t *Terminal) printf(format string, args ...interface{}) {
+	t.Lock()
+	defer t.Unlock()
+	fmt.Fprintf(t.out, format, args...)
+}
+
+// command runs a line of input, returning false once the player quits.
+func (t *Terminal) command(line string) bool {
+	var err error
+	switch {
+	case line == "q" || line == "quit":
+		return false
+	case line == "?" || line == "help":
+		t.printf("%s", TERMINAL_HELP)
+	case line == "" || line == "b" || line == "board":
+		t.Lock()
+		t.draw()
+		t.Unlock()
+	case line == "n" || line == "new":
+		err = t.client.Reset(t.code, t.name)
+	case strings.HasPrefix(line, "say "):
+		err = t.client.Chat(t.code, t.name, strings.TrimSpace(line[len("say "):]))
+	default:
+		n, perr := strconv.Atoi(line)
+		if perr != nil {
+			t.printf("Unknown command %q, ? for help\n", line)
+			break
+		}
+		t.Lock()
+		index, ok := t.hole(n)
+		t.Unlock()
+		if !ok {
+			t.printf("There's no hole %d for you to play\n", n)
+			break
+		}
+		err = t.client.Move(t.code, t.name, index)
+	}
+	if err != nil {
+		t.printf("Could not do that: %s\n", err)
+	}
+	return true
+}
+
+// hole finds the board index of the nth hole the player can choose from.
+// Must be called with the lock held.
+func (t *Terminal) hole(n int) (int, bool) {
+	if t.room == nil {
+		return 0, false
+	}
+	holes := SeatHoles(t.room.Board, ChoosingSeat(t.room, t.name))
+	if n < 1 || n > len(holes) {
+		return 0, false
+	}
+	return holes[n-1], true
+}
+
+// follow keeps the room's stream open, reconnecting when it drops.
+func (t *Terminal) follow() {
+	wait := time.Second
+	for reconnect := false; ; reconnect = true {
+		stream, err := t.client.Stream(t.code, t.name)
+		if err == nil {
+			t.Lock()
+			select {
+			case <-t.done:
+				t.Unlock()
+				stream.Close()
+				return
+			default:
+				t.stream = stream
+			}
+			t.Unlock()
+			wait = time.Second
+			if reconnect {
+				// Catch up on anything missed while disconnected
+				t.refresh()
+			}
+			t.read(stream)
+		}
+
+		select {
+		case <-t.done:
+			return
+		default:
+		}
+		t.printf("Lost the connection to the room, reconnecting\n")
+		select {
+		case <-t.done:
+			return
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > TERMINAL_RECONNECT {
+			wait = TERMINAL_RECONNECT
+		}
+	}
+}
+
+// read handles the stream's messages until the connection closes.
+func (t *Terminal) read(stream RoomStream) {
+	defer stream.Close()
+	for {
+		var msg struct {
+			Heartbeat *bool        `json:"heartbeat"`
+			Ping      *string      `json:"ping"`
+			Chat      *ChatMessage `json:"chat"`
+			Error     *string      `json:"error"`
+			Restart   *bool        `json:"restart"`
+		}
+		if err := stream.ReadJSON(&msg); err != nil {
+			return
+		}
+		switch {
+		case msg.Heartbeat != nil:
+		case msg.Ping != nil:
+			t.printf("%s is waiting for you to play\n", *msg.Ping)
+		case msg.Chat != nil && msg.Chat.Reaction != "":
+			t.printf("%s reacted %s\n", msg.Chat.Name, msg.Chat.Reaction)
+		case msg.Chat != nil:
+			t.printf("%s: %s\n", msg.Chat.Name, msg.Chat.Text)
+		case msg.Error != nil:
+			t.printf("Could not do that: %s\n", *msg.Error)
+		case msg.Restart != nil:
+			t.printf("The server is restarting\n")
+		default:
+			t.refresh()
+		}
+	}
+}
+
+// refresh fetches the room and its new history, printing both.
+func (t *Terminal) refresh() {
+	room, err := t.client.State(t.code)
+	if err != nil {
+		t.printf("Could not fetch the room: %s\n", err)
+		return
+	}
+	t.Lock()
+	seen := t.seen
+	t.Unlock()
+
+	// The page starts later than asked for when older entries were dropped
+	entries := []HistoryEntry{}
+	next := seen
+	for next < room.HistoryLen {
+		page, err := t.client.History(t.code, next)
+		if err != nil || len(page.Entries) == 0 {
+			break
+		}
+		entries = append(entries, page.Entries...)
+		next = page.Offset + len(page.Entries)
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	// Another refresh may have printed these in the meantime
+	if seen == t.seen {
+		for _, entry := range entries {
+			if text := entry.Text(); text != "" {
+				fmt.Fprintf(t.out, "> %s\n", strings.Replace(text, "\n", "\n> ", -1))
+			}
+		}
+		t.seen = next
+	}
+	t.room = room
+	t.draw()
+}
+
+// draw prints the board in one write, so a terminal that redraws its prompt
+// around output does so once. Must be called with the lock held.
+func (t *Terminal) draw() {
+	if t.room != nil {
+		var buf bytes.Buffer
+		RenderBoard(&buf, t.room, t.name)
+		t.out.Write(buf.Bytes())
+	}
+}
+
+// ChoosingSeat is the seat whose holes a player picks from: their own, or
+// whoever's turn it is when one player plays every seat.
+func ChoosingSeat(room *ClientRoom, name string) int {
+	if room.SPMode {
+		return room.Board.CurrentPlayer
+	}
+	for idx, player := range room.Players {
+		if player.Name == name && idx < room.Board.NumPlayers {
+			return idx
+		}
+	}
+	return -1
+}
+
+// SeatHoles are the board indexes of a seat's holes, in sowing order.
+func SeatHoles(board *GameBoard, seat int) []int {
+	holes := []int{}
+	for idx, hole := range board.Holes {
+		if hole.Player == seat && !hole.Winhole {
+			holes = append(holes, idx)
+		}
+	}
+	return holes
+}
+
+func seatName(room *ClientRoom, seat int) string {
+	if seat < len(room.Players) {
+		return room.Players[seat].Name
+	}
+	return "(empty seat)"
+}
+
+// RenderBoard draws a room's board as text, a row of holes and a store for
+// each seat. Stones are sown left to right along a row and on to the next,
+// and from the last row back to the first. The viewer's holes are numbered.
+func RenderBoard(w io.Writer, room *ClientRoom, viewer string) {
+	board := room.Board
+	status := ""
+	switch {
+	case len(room.Players) < board.NumPlayers && !room.SPMode:
+		status = fmt.Sprintf("waiting for %d more players", board.NumPlayers-len(room.Players))
+	case board.Finished:
+		winner, _ := Leader(board.SideScores())
+		switch {
+		case winner < 0:
+			status = "game over, it's a tie"
+		case board.Teams != nil:
+			status = fmt.Sprintf("game over, team %d wins", winner+1)
+		default:
+			status = fmt.Sprintf("game over, %s wins", seatName(room, winner))
+		}
+	default:
+		status = seatName(room, board.CurrentPlayer) + " to play"
+	}
+	fmt.Fprintf(w, "\nRoom %s, game %d, turn %d: %s\n\n", room.Code, room.GameNumber, board.Turn, status)
+
+	width := 0
+	for seat := 0; seat < board.NumPlayers; seat++ {
+		if n := len(seatName(room, seat)); n > width {
+			width = n
+		}
+	}
+	choosing := ChoosingSeat(room, viewer)
+	for seat := 0; seat < board.NumPlayers; seat++ {
+		marker := " "
+		if seat == board.CurrentPlayer && !board.Finished {
+			marker = "*"
+		}
+		row := fmt.Sprintf("%s %-*s ", marker, width, seatName(room, seat))
+		labels := strings.Repeat(" ", len(row))
+		store := 0
+		n := 0
+		for _, hole := range board.Holes {
+			if hole.Player != seat {
+				continue
+			}
+			if hole.Winhole {
+				store = len(hole.Stones)
+				continue
+			}
+			n += 1
+			row += fmt.Sprintf("%5d", len(hole.Stones))
+			labels += fmt.Sprintf("%5s", "("+strconv.Itoa(n)+")")
+		}
+		row += fmt.Sprintf("  | %d", store)
+		if board.Teams != nil {
+			row += fmt.Sprintf("  team %d", board.Teams[seat]+1)
+		}
+		fmt.Fprintln(w, row)
+		if seat == choosing {
+			fmt.Fprintln(w, labels)
+		}
+	}
+	fmt.Fprintln(w)
+}
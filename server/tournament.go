@@ -0,0 +1,453 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	FORMAT_SINGLE      = "single"
+	FORMAT_DOUBLE      = "double"
+	FORMAT_ROUND_ROBIN = "round_robin"
+
+	BRACKET_WINNERS = "winners"
+	BRACKET_LOSERS  = "losers"
+	BRACKET_FINAL   = "final"
+	BRACKET_ROBIN   = "round_robin"
+
+	MAX_PARTICIPANTS = 64
+)
+
+// Match is one game between two participants. A slot is ready once the
+// participant playing in it is known; a ready slot with no name is a bye. A
+// grand final reset that wasn't needed is finished without players.
+type Match struct {
+	Id         int       `json:"id"`
+	Bracket    string    `json:"bracket"`
+	Round      int       `json:"round"`
+	Players    [2]string `json:"players"`
+	Ready      [2]bool   `json:"ready"`
+	Room       string    `json:"room"`
+	Winner     string    `json:"winner"`
+	Loser      string    `json:"loser"`
+	Tied       bool      `json:"tied"`
+	Scores     [2]int    `json:"scores"` // stones each player had in their winhole
+	Finished   bool      `json:"finished"`
+	WinnerTo   int       `json:"winner_to"` // match the winner moves to, -1 for none
+	WinnerSlot int       `json:"winner_slot"`
+	LoserTo    int       `json:"loser_to"` // match the loser moves to, -1 for none
+	LoserSlot  int       `json:"loser_slot"`
+}
+
+type Standing struct {
+	Name      string `json:"name"`
+	Wins      int    `json:"wins"`
+	Ties      int    `json:"ties"`
+	Losses    int    `json:"losses"`
+	ScoreDiff int    `json:"score_diff"` // stones scored minus stones conceded
+}
+
+type Tournament struct {
+	sync.RWMutex
+	Code         string        `json:"code"`
+	Format       string        `json:"format"`
+	Participants []string      `json:"participants"`
+	Matches      []*Match      `json:"matches"`
+	Finished     bool          `json:"finished"`
+	Champion     string        `json:"champion"`
+	Conns        map[Conn]bool `json:"-"`
+}
+
+type LockedTournaments struct {
+	sync.RWMutex
+	Tournaments map[string]*Tournament
+}
+
+func NewTournament(code string, format string, participants []string) (*Tournament, error) {
+	if len(participants) < 2 {
+		return nil, errors.New("a tournament needs at least 2 participants")
+	}
+	if len(participants) > MAX_PARTICIPANTS {
+		return nil, fmt.Errorf("a tournament can have at most %d participants", MAX_PARTICIPANTS)
+	}
+	seen := map[string]bool{}
+	participants = append([]string{}, participants...)
+	for i, name := range participants {
+		name, err := CleanName(name)
+		if err != nil {
+			return nil, err
+		}
+		participants[i] = name
+		if seen[name] {
+			return nil, errors.New("participant " + name + " entered twice")
+		}
+		seen[name] = true
+	}
+
+	t := &Tournament{
+		Code:         code,
+		Format:       format,
+		Participants: participants,
+		Matches:      []*Match{},
+		Conns:        map[Conn]bool{},
+	}
+	switch format {
+	case FORMAT_SINGLE:
+		t.buildElimination(false)
+	case FORMAT_DOUBLE:
+		t.buildElimination(true)
+	case FORMAT_ROUND_ROBIN:
+		t.buildRoundRobin()
+	default:
+		return nil, errors.New("unknown tournament format " + format)
+	}
+	return t, nil
+}
+
+func (t *Tournament) addMatch(bracket string, round int) *Match {
+	m := &Match{Id: len(t.Matches), Bracket: bracket, Round: round, WinnerTo: -1, LoserTo: -1}
+	t.Matches = append(t.Matches, m)
+	return m
+}
+
+// SeedOrder is the standard bracket order of seeds 1..size, so the top seeds
+// meet as late as possible.
+func SeedOrder(size int) []int {
+	order := []int{1}
+	for n := 1; n < size; n *= 2 {
+		next := []int{}
+		for _, seed := range order {
+			next = append(next, seed, 2*n+1-seed)
+		}
+		order = next
+	}
+	return order
+}
+
+// buildElimination lays out the winners bracket and, for double elimination,
+// the losers bracket and a grand final with its reset match. Empty seeds are
+// byes.
+func (t *Tournament) buildElimination(double bool) {
+	size := 1
+	rounds := 0
+	for size < len(t.Participants) {
+		size *= 2
+		rounds += 1
+	}
+
+	// Winners bracket
+	wb := make([][]*Match, rounds+1)
+	for r := 1; r <= rounds; r++ {
+		for i := 0; i < size>>uint(r); i++ {
+			wb[r] = append(wb[r], t.addMatch(BRACKET_WINNERS, r))
+		}
+	}
+	for r := 1; r < rounds; r++ {
+		for i, m := range wb[r] {
+			m.WinnerTo, m.WinnerSlot = wb[r+1][i/2].Id, i%2
+		}
+	}
+
+	if double {
+		// Odd losers rounds pair up survivors, even rounds bring in the
+		// losers of the next winners round
+		lb := make([][]*Match, 2*rounds-1)
+		for r := 1; r <= 2*(rounds-1); r++ {
+			n := size >> uint((r+1)/2+1)
+			for i := 0; i < n; i++ {
+				lb[r] = append(lb[r], t.addMatch(BRACKET_LOSERS, r))
+			}
+		}
+		final := t.addMatch(BRACKET_FINAL, 1)
+		reset := t.addMatch(BRACKET_FINAL, 2)
+		final.WinnerTo, final.WinnerSlot = reset.Id, 0
+		final.LoserTo, final.LoserSlot = reset.Id, 1
+		wb[rounds][0].WinnerTo, wb[rounds][0].WinnerSlot = final.Id, 0
+
+		if rounds == 1 {
+			wb[1][0].LoserTo, wb[1][0].LoserSlot = final.Id, 1
+		} else {
+			for i, m := range wb[1] {
+				m.LoserTo, m.LoserSlot = lb[1][i/2].Id, i%2
+			}
+			for r := 2; r <= rounds; r++ {
+				for i, m := range wb[r] {
+					m.LoserTo, m.LoserSlot = lb[2*(r-1)][i].Id, 1
+				}
+			}
+			for r := 1; r < 2*(rounds-1); r++ {
+				for i, m := range lb[r] {
+					if r%2 == 1 {
+						m.WinnerTo, m.WinnerSlot = lb[r+1][i].Id, 0
+					} else {
+						m.WinnerTo, m.WinnerSlot = lb[r+1][i/2].Id, i%2
+					}
+				}
+			}
+			last := lb[2*(rounds-1)][0]
+			last.WinnerTo, last.WinnerSlot = final.Id, 1
+		}
+	}
+
+	for i, seed := range SeedOrder(size) {
+		name := ""
+		if seed <= len(t.Participants) {
+			name = t.Participants[seed-1]
+		}
+		t.setSlot(wb[1][i/2].Id, i%2, name)
+	}
+}
+
+// buildRoundRobin pairs everyone with everyone using the circle method, so
+// each round has every participant playing at most once.
+func (t *Tournament) buildRoundRobin() {
+	names := append([]string{}, t.Participants...)
+	if len(names)%2 == 1 {
+		names = append(names, "")
+	}
+	n := len(names)
+	for r := 1; r < n; r++ {
+		for i := 0; i < n/2; i++ {
+			a, b := names[i], names[n-1-i]
+			if a == "" || b == "" {
+				continue
+			}
+			m := t.addMatch(BRACKET_ROBIN, r)
+			m.Players = [2]string{a, b}
+			m.Ready = [2]bool{true, true}
+		}
+		// Keep the first name fixed and rotate the rest
+		names = append([]string{names[0], names[n-1]}, names[1:n-1]...)
+	}
+}
+
+// setSlot puts a participant, or a bye if name is empty, into a match slot
+// and settles any match left with a bye.
+func (t *Tournament) setSlot(id int, slot int, name string) {
+	m := t.Matches[id]
+	m.Players[slot] = name
+	m.Ready[slot] = true
+	if !m.Ready[0] || !m.Ready[1] || m.Finished {
+		return
+	}
+	if m.Players[0] == "" || m.Players[1] == "" {
+		winner := m.Players[0] + m.Players[1]
+		t.finish(m, winner, "")
+	}
+}
+
+// finish records the result of a match and moves the players on.
+func (t *Tournament) finish(m *Match, winner string, loser string) {
+	m.Winner = winner
+	m.Loser = loser
+	m.Finished = true
+	// The winners bracket champion hasn't lost yet, so only a grand final they
+	// lose goes on to the reset match. Otherwise the reset is settled unplayed.
+	if m.Bracket == BRACKET_FINAL && m.Round == 1 && winner == m.Players[0] {
+		t.Matches[m.WinnerTo].Finished = true
+		t.Finished = true
+		t.Champion = winner
+		return
+	}
+	if m.WinnerTo >= 0 {
+		t.setSlot(m.WinnerTo, m.WinnerSlot, winner)
+	} else if m.Bracket != BRACKET_ROBIN && m.Bracket != BRACKET_LOSERS {
+		t.Finished = true
+		t.Champion = winner
+	}
+	if m.LoserTo >= 0 {
+		t.setSlot(m.LoserTo, m.LoserSlot, loser)
+	}
+}
+
+func (s *Standing) points() int {
+	return 2*s.Wins + s.Ties
+}
+
+// played are the finished matches that weren't byes.
+func (t *Tournament) played() []*Match {
+	out := []*Match{}
+	for _, m := range t.Matches {
+		if m.Finished && m.Players[0] != "" && m.Players[1] != "" {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// Standings ranks participants by points, two for a win and one for a tie.
+// Participants level on points are ranked by the points they took from the
+// matches between them, then by score difference, then by seed.
+func (t *Tournament) Standings() []Standing {
+	byName := map[string]*Standing{}
+	out := []*Standing{}
+	for _, name := range t.Participants {
+		s := &Standing{Name: name}
+		byName[name] = s
+		out = append(out, s)
+	}
+	played := t.played()
+	for _, m := range played {
+		a, b := byName[m.Players[0]], byName[m.Players[1]]
+		a.ScoreDiff += m.Scores[0] - m.Scores[1]
+		b.ScoreDiff += m.Scores[1] - m.Scores[0]
+		if m.Tied {
+			a.Ties += 1
+			b.Ties += 1
+			continue
+		}
+		byName[m.Winner].Wins += 1
+		byName[m.Loser].Losses += 1
+	}
+	headToHead := map[string]int{}
+	for _, m := range played {
+		a, b := byName[m.Players[0]], byName[m.Players[1]]
+		if a.points() != b.points() {
+			continue
+		}
+		if m.Tied {
+			headToHead[a.Name] += 1
+			headToHead[b.Name] += 1
+		} else {
+			headToHead[m.Winner] += 2
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if pi, pj := out[i].points(), out[j].points(); pi != pj {
+			return pi > pj
+		}
+		if hi, hj := headToHead[out[i].Name], headToHead[out[j].Name]; hi != hj {
+			return hi > hj
+		}
+		return out[i].ScoreDiff > out[j].ScoreDiff
+	})
+	standings := []Standing{}
+	for _, s := range out {
+		standings = append(standings, *s)
+	}
+	return standings
+}
+
+// OpenRooms creates a room for every match whose players are known but which
+// has no room yet. Must be called with the tournament locked.
+func (t *Tournament) OpenRooms(rooms *LockedRooms) error {
+	rooms.Lock()
+	defer rooms.Unlock()
+
+	if rooms.Closed {
+		return ErrShuttingDown
+	}
+	for _, m := range t.Matches {
+		if m.Finished || m.Room != "" || !m.Ready[0] || !m.Ready[1] {
+			continue
+		}
+		if len(rooms.Rooms) >= config.Limits.MaxRooms {
+			return ErrServerFull
+		}
+		code, ok := rooms.UniqueCode(false)
+		if !ok {
+			return errors.New("could not create unique room code")
+		}
+		room, err := NewRoom(code, 2, false)
+		if err != nil {
+			return err
+		}
+		for _, name := range m.Players {
+			room.Players = append(room.Players, &Player{Name: name, Conns: map[Conn]bool{}})
+		}
+		room.Tournament = t.Code
+		room.Match = m.Id
+		room.AddHistory(NewHistoryMessage(fmt.Sprintf("%s vs %s in tournament %s", m.Players[0], m.Players[1], t.Code)))
+		rooms.Rooms[code] = room
+		m.Room = code
+	}
+	return nil
+}
+
+// ReportResult advances a tournament after a game in one of its rooms has
+// finished. A tied knockout game has to be replayed. Must be called with the
+// room locked.
+func (l *LockedTournaments) ReportResult(rooms *LockedRooms, room *Room) error {
+	if room.Tournament == "" || len(room.Results) == 0 {
+		return nil
+	}
+	l.RLock()
+	t, ok := l.Tournaments[room.Tournament]
+	l.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if room.Match < 0 || room.Match >= len(t.Matches) {
+		return errors.New("room is not a match of its tournament")
+	}
+	m := t.Matches[room.Match]
+	if m.Finished {
+		return nil
+	}
+
+	result := room.Results[len(room.Results)-1]
+	m.Scores = [2]int{result.Scores[m.Players[0]], result.Scores[m.Players[1]]}
+	if result.Tied || len(result.Winners) != 1 {
+		if m.Bracket == BRACKET_ROBIN {
+			m.Tied = true
+			m.Finished = true
+		} else {
+			room.AddHistory(NewHistoryMessage("Match tied, reset to play it again!"))
+			return nil
+		}
+	} else {
+		winner := result.Winners[0]
+		loser := m.Players[0]
+		if loser == winner {
+			loser = m.Players[1]
+		} else if m.Players[1] != winner {
+			return fmt.Errorf("%s won match %d but doesn't play in it", winner, m.Id)
+		}
+		t.finish(m, winner, loser)
+	}
+
+	if m.Bracket == BRACKET_ROBIN {
+		done := true
+		for _, other := range t.Matches {
+			done = done && other.Finished
+		}
+		if done {
+			t.Finished = true
+			t.Champion = t.Standings()[0].Name
+		}
+	}
+
+	err := t.OpenRooms(rooms)
+	t.NotifyWatchers()
+	return err
+}
+
+func (t *Tournament) MarshalJSON() ([]byte, error) {
+	type Alias Tournament
+	return json.Marshal(struct {
+		*Alias
+		TournamentDerived
+	}{(*Alias)(t), TournamentDerived{t.Standings()}})
+}
+
+// TournamentDerived are the fields MarshalJSON adds to a tournament.
+type TournamentDerived struct {
+	Standings []Standing `json:"standings"`
+}
+
+func (t *Tournament) NotifyWatchers() {
+	for ws := range t.Conns {
+		err := ws.WriteJSON(t)
+		if err != nil {
+			ws.Close()
+			delete(t.Conns, ws)
+		}
+	}
+}
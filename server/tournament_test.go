@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func participants(n int) []string {
+	names := []string{}
+	for i := 0; i < n; i++ {
+		names = append(names, string(rune('a'+i)))
+	}
+	return names
+}
+
+// win finishes a ready match as a win for one of its players.
+func win(t *testing.T, tour *Tournament, id int, winner string) {
+	t.Helper()
+	m := tour.Matches[id]
+	if m.Finished || !m.Ready[0] || !m.Ready[1] {
+		t.Fatalf("match %d isn't ready to play: %+v", id, m)
+	}
+	loser := m.Players[0]
+	if loser == winner {
+		loser = m.Players[1]
+	} else if m.Players[1] != winner {
+		t.Fatalf("%s doesn't play in match %d: %v", winner, id, m.Players)
+	}
+	tour.finish(m, winner, loser)
+}
+
+func TestBuildTournament(t *testing.T) {
+	tests := []struct {
+		format   string
+		players  int
+		matches  int
+		finished int // matches settled by byes
+		ok       bool
+	}{
+		{FORMAT_SINGLE, 1, 0, 0, false},
+		{FORMAT_SINGLE, 2, 1, 0, true},
+		{FORMAT_SINGLE, 4, 3, 0, true},
+		{FORMAT_SINGLE, 5, 7, 3, true},
+		{FORMAT_SINGLE, 8, 7, 0, true},
+		{FORMAT_DOUBLE, 2, 3, 0, true},
+		{FORMAT_DOUBLE, 3, 7, 1, true},
+		{FORMAT_DOUBLE, 4, 7, 0, true},
+		{FORMAT_DOUBLE, 8, 15, 0, true},
+		{FORMAT_ROUND_ROBIN, 4, 6, 0, true},
+		{FORMAT_ROUND_ROBIN, 5, 10, 0, true},
+		{FORMAT_SINGLE, MAX_PARTICIPANTS + 1, 0, 0, false},
+		{"swiss", 4, 0, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s %d", test.format, test.players), func(t *testing.T) {
+			tour, err := NewTournament("TEST", test.format, participants(test.players))
+			if (err == nil) != test.ok {
+				t.Fatalf("got error %v, want ok %v", err, test.ok)
+			}
+			if err != nil {
+				return
+			}
+			if len(tour.Matches) != test.matches {
+				t.Errorf("%d matches, want %d", len(tour.Matches), test.matches)
+			}
+			finished := 0
+			for _, m := range tour.Matches {
+				if m.Finished {
+					finished += 1
+					if m.Players[0] != "" && m.Players[1] != "" {
+						t.Errorf("match %d between %v finished unplayed", m.Id, m.Players)
+					}
+				}
+			}
+			if finished != test.finished {
+				t.Errorf("%d matches settled by byes, want %d", finished, test.finished)
+			}
+			if tour.Finished {
+				t.Errorf("tournament finished before any match was played")
+			}
+		})
+	}
+}
+
+func TestDuplicateParticipants(t *testing.T) {
+	if _, err := NewTournament("TEST", FORMAT_SINGLE, []string{"a", "b", "a"}); err == nil {
+		t.Errorf("participant entered twice was accepted")
+	}
+}
+
+func TestDoubleEliminationRouting(t *testing.T) {
+	// Seeds a and d meet in match 0, b and c in match 1, then the winners
+	// final is 2, losers rounds are 3 and 4, the grand final 5 and its reset 6
+	tour, err := NewTournament("TEST", FORMAT_DOUBLE, participants(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := []struct {
+		id         int
+		bracket    string
+		winnerTo   int
+		winnerSlot int
+		loserTo    int
+		loserSlot  int
+	}{
+		{0, BRACKET_WINNERS, 2, 0, 3, 0},
+		{1, BRACKET_WINNERS, 2, 1, 3, 1},
+		{2, BRACKET_WINNERS, 5, 0, 4, 1},
+		{3, BRACKET_LOSERS, 4, 0, -1, 0},
+		{4, BRACKET_LOSERS, 5, 1, -1, 0},
+		{5, BRACKET_FINAL, 6, 0, 6, 1},
+		{6, BRACKET_FINAL, -1, 0, -1, 0},
+	}
+	for _, route := range routes {
+		m := tour.Matches[route.id]
+		if m.Bracket != route.bracket || m.WinnerTo != route.winnerTo || m.WinnerSlot != route.winnerSlot ||
+			m.LoserTo != route.loserTo || m.LoserSlot != route.loserSlot {
+			t.Errorf("match %d in %s sends its winner to %d/%d and loser to %d/%d, want %s %d/%d and %d/%d",
+				m.Id, m.Bracket, m.WinnerTo, m.WinnerSlot, m.LoserTo, m.LoserSlot,
+				route.bracket, route.winnerTo, route.winnerSlot, route.loserTo, route.loserSlot)
+		}
+	}
+	if got := tour.Matches[0].Players; got != [2]string{"a", "d"} {
+		t.Errorf("match 0 between %v, want a and d", got)
+	}
+}
+
+func TestDoubleEliminationFinal(t *testing.T) {
+	type result struct {
+		match  int
+		winner string
+	}
+	// Up to the grand final a is unbeaten and b came through the losers bracket
+	toFinal := []result{{0, "a"}, {1, "b"}, {2, "a"}, {3, "d"}, {4, "b"}}
+	tests := []struct {
+		name     string
+		results  []result
+		champion string
+		reset    bool // whether the reset match is played
+	}{
+		{"unbeaten champion wins the final", []result{{5, "a"}}, "a", false},
+		{"reset won by the winners bracket champion", []result{{5, "b"}, {6, "a"}}, "a", true},
+		{"reset won by the losers bracket champion", []result{{5, "b"}, {6, "b"}}, "b", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tour, err := NewTournament("TEST", FORMAT_DOUBLE, participants(4))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range append(append([]result{}, toFinal...), test.results...) {
+				if tour.Finished {
+					t.Fatalf("tournament finished before match %d", r.match)
+				}
+				win(t, tour, r.match, r.winner)
+			}
+			if !tour.Finished || tour.Champion != test.champion {
+				t.Errorf("finished %v with champion %q, want %q", tour.Finished, tour.Champion, test.champion)
+			}
+			reset := tour.Matches[6]
+			if !reset.Finished || (reset.Players[0] != "") != test.reset {
+				t.Errorf("reset match %+v, want played %v", reset, test.reset)
+			}
+			for _, s := range tour.Standings() {
+				if s.Name == test.champion && s.Losses > 1 {
+					t.Errorf("champion %s lost %d times", s.Name, s.Losses)
+				}
+			}
+		})
+	}
+}
+
+func TestByeAdvances(t *testing.T) {
+	// With 3 players a has a bye, so only b and c play the first round and
+	// the losers bracket waits for its loser
+	tour, err := NewTournament("TEST", FORMAT_DOUBLE, participants(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	final := tour.Matches[2]
+	if final.Players[0] != "a" || !final.Ready[0] || final.Ready[1] {
+		t.Fatalf("winners final %+v, want a waiting for an opponent", final)
+	}
+	win(t, tour, 1, "b")
+	// The losers bracket's first match had a bye against c, so c moves on
+	if m := tour.Matches[3]; !m.Finished || m.Winner != "c" {
+		t.Errorf("losers match %+v, want c through on a bye", m)
+	}
+	win(t, tour, 2, "a")
+	win(t, tour, 4, "b")
+	win(t, tour, 5, "a")
+	if !tour.Finished || tour.Champion != "a" {
+		t.Errorf("finished %v with champion %q, want a", tour.Finished, tour.Champion)
+	}
+}
+
+func TestRoundRobinPairings(t *testing.T) {
+	for players := 2; players <= 7; players++ {
+		t.Run(fmt.Sprint(players), func(t *testing.T) {
+			tour, err := NewTournament("TEST", FORMAT_ROUND_ROBIN, participants(players))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pairs := map[[2]string]int{}
+			rounds := map[int]map[string]bool{}
+			for _, m := range tour.Matches {
+				a, b := m.Players[0], m.Players[1]
+				if a > b {
+					a, b = b, a
+				}
+				pairs[[2]string{a, b}] += 1
+				if rounds[m.Round] == nil {
+					rounds[m.Round] = map[string]bool{}
+				}
+				for _, name := range m.Players {
+					if rounds[m.Round][name] {
+						t.Errorf("%s plays twice in round %d", name, m.Round)
+					}
+					rounds[m.Round][name] = true
+				}
+			}
+			if len(pairs) != players*(players-1)/2 {
+				t.Errorf("%d pairings, want %d", len(pairs), players*(players-1)/2)
+			}
+			for pair, n := range pairs {
+				if n != 1 {
+					t.Errorf("%v meet %d times", pair, n)
+				}
+			}
+		})
+	}
+}
+
+func TestExpireKeepsTournamentRooms(t *testing.T) {
+	rooms := &LockedRooms{Rooms: map[string]*Room{}}
+	for _, code := range []string{"IDLE", "MATCH", "FRESH"} {
+		room, err := NewRoom(code, 2, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != "FRESH" {
+			room.Touched = time.Now().Add(-2 * time.Hour)
+		}
+		rooms.Rooms[code] = room
+	}
+	rooms.Rooms["MATCH"].Tournament = "CUP"
+
+	if n := rooms.Expire(time.Hour); n != 1 {
+		t.Errorf("expired %d rooms, want 1", n)
+	}
+	for code, want := range map[string]bool{"IDLE": false, "MATCH": true, "FRESH": true} {
+		if _, ok := rooms.Rooms[code]; ok != want {
+			t.Errorf("room %s kept %v, want %v", code, ok, want)
+		}
+	}
+}
+
+func TestRoundRobinTiebreak(t *testing.T) {
+	type result struct {
+		players [2]string
+		winner  string // empty for a tie
+		scores  [2]int
+	}
+	tests := []struct {
+		name    string
+		players int
+		results []result
+		want    []string
+	}{
+		{
+			// a and b are level on points but b beat a, c and d likewise but d beat c
+			"head to head", 4,
+			[]result{
+				{[2]string{"a", "b"}, "b", [2]int{20, 21}},
+				{[2]string{"a", "c"}, "a", [2]int{30, 2}},
+				{[2]string{"a", "d"}, "a", [2]int{30, 2}},
+				{[2]string{"b", "c"}, "c", [2]int{10, 20}},
+				{[2]string{"b", "d"}, "b", [2]int{20, 10}},
+				{[2]string{"c", "d"}, "d", [2]int{10, 12}},
+			},
+			[]string{"b", "a", "d", "c"},
+		},
+		{
+			"score difference", 3,
+			[]result{
+				{[2]string{"a", "b"}, "a", [2]int{10, 5}},
+				{[2]string{"b", "c"}, "b", [2]int{6, 4}},
+				{[2]string{"a", "c"}, "c", [2]int{6, 7}},
+			},
+			[]string{"a", "c", "b"},
+		},
+		{
+			"ties count one point", 3,
+			[]result{
+				{[2]string{"a", "b"}, "", [2]int{8, 8}},
+				{[2]string{"b", "c"}, "", [2]int{8, 8}},
+				{[2]string{"a", "c"}, "c", [2]int{7, 9}},
+			},
+			[]string{"c", "b", "a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tour, err := NewTournament("TEST", FORMAT_ROUND_ROBIN, participants(test.players))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range test.results {
+				for _, m := range tour.Matches {
+					if m.Players != r.players && m.Players != [2]string{r.players[1], r.players[0]} {
+						continue
+					}
+					m.Scores = r.scores
+					if m.Players != r.players {
+						m.Scores = [2]int{r.scores[1], r.scores[0]}
+					}
+					if r.winner == "" {
+						m.Tied = true
+						m.Finished = true
+					} else {
+						win(t, tour, m.Id, r.winner)
+					}
+				}
+			}
+			got := []string{}
+			for _, s := range tour.Standings() {
+				got = append(got, s.Name)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("standings %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReportResult(t *testing.T) {
+	rooms := &LockedRooms{Rooms: map[string]*Room{}}
+	tour, err := NewTournament("CUP", FORMAT_SINGLE, participants(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tours := &LockedTournaments{Tournaments: map[string]*Tournament{"CUP": tour}}
+	if err := tour.OpenRooms(rooms); err != nil {
+		t.Fatal(err)
+	}
+	m := tour.Matches[0]
+	room := rooms.Rooms[m.Room]
+
+	api := &API{Rooms: rooms, Tournaments: tours}
+	if _, _, err := api.JoinRoom(room.Code, JoinRequest{Name: "c"}); err != ErrLobbyFull {
+		t.Errorf("third player joining a match got %v, want %v", err, ErrLobbyFull)
+	}
+	if _, _, err := api.JoinRoom(room.Code, JoinRequest{Name: "a"}); err != nil {
+		t.Errorf("match player rejoining got %v", err)
+	}
+
+	room.Results = append(room.Results, GameResult{Winners: []string{"c"}, Scores: map[string]int{"a": 10, "c": 14}})
+	if err := tours.ReportResult(rooms, room); err == nil || m.Finished {
+		t.Errorf("win by an outsider got %v, finished %v", err, m.Finished)
+	}
+	room.Results = append(room.Results, GameResult{Winners: []string{"b"}, Scores: map[string]int{"a": 10, "b": 14}})
+	if err := tours.ReportResult(rooms, room); err != nil {
+		t.Fatal(err)
+	}
+	if !tour.Finished || tour.Champion != "b" || m.Scores != [2]int{10, 14} {
+		t.Errorf("finished %v with champion %q and scores %v, want b winning 14 to 10", tour.Finished, tour.Champion, m.Scores)
+	}
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const API_V1_PREFIX = "/api/v1"
+
+// QueryParam is an optional query parameter of a route.
+type QueryParam struct {
+	Name string
+	Type string // JSON schema type, integer or string
+}
+
+// V1Route describes a route of the versioned API. The router and the OpenAPI
+// document are both built from V1Routes, so the document always matches what
+// the server serves.
+type V1Route struct {
+	Method       string
+	Pattern      string
+	Name         string // names the route in metrics and logs, and picks its rate limit
+	Summary      string
+	Query        []QueryParam
+	Request      interface{} // nil pointer of the request body type, or nil for no body
+	Response     interface{} // nil pointer of the response body type, or nil for no body
+	ContentTypes []string    // of a response that isn't described by Response
+	Stream       bool        // upgrades to a websocket, see the stream schema
+	Status       int         // on success
+	AlsoStatus   int         // another success status with the same response, 0 for none
+	Errors       []int
+	Handler      func(*API) http.HandlerFunc
+}
+
+var (
+	roomErrors    = []int{http.StatusBadRequest, http.StatusNotFound}
+	playerErrors  = []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict}
+	createErrors  = []int{http.StatusBadRequest, http.StatusServiceUnavailable}
+	moveErrors    = []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict}
+	streamErrors  = []int{http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable}
+	requestErrors = []int{http.StatusBadRequest}
+)
+
+func V1Routes() []V1Route {
+	return []V1Route{
+		{Method: http.MethodGet, Pattern: "/rooms", Name: "rooms", Summary: "List public rooms, optionally of one board size",
+			Query: []QueryParam{{"size", "integer"}}, Response: (*[]RoomListing)(nil), Status: http.StatusOK, Errors: requestErrors, Handler: V1ListRooms},
+		{Method: http.MethodPost, Pattern: "/rooms", Name: "create", Summary: "Create a room",
+			Request: (*CreateRoomRequest)(nil), Response: (*CreateRoomResponse)(nil), Status: http.StatusCreated, Errors: createErrors, Handler: V1CreateRoom},
+		{Method: http.MethodGet, Pattern: "/rooms/{code}", Name: "state", Summary: "Get a room's state",
+			Response: (*Room)(nil), Status: http.StatusOK, Errors: roomErrors, Handler: V1GetRoom},
+		{Method: http.MethodPost, Pattern: "/rooms/{code}/players", Name: "join", Summary: "Join a room, answering 200 for a player already in it",
+			Request: (*JoinRequest)(nil), Response: (*Room)(nil), Status: http.StatusCreated, AlsoStatus: http.StatusOK, Errors: append(playerErrors, http.StatusForbidden), Handler: V1JoinRoom},
+		{Method: http.MethodGet, Pattern: "/rooms/{code}/players/{name}/stream", Name: "stream", Summary: "Open a player's websocket",
+			Stream: true, Status: http.StatusSwitchingProtocols, Errors: streamErrors, Handler: V1Stream},
+		{Method: http.MethodPut, Pattern: "/rooms/{code}/players/{name}/opt-out", Name: "opt-out", Summary: "Set whether a player's drinks are always substituted",
+			Request: (*OptOutRequest)(nil), Status: http.StatusNoContent, Errors: roomErrors, Handler: V1SetOptOut},
+		{Method: http.MethodPut, Pattern: "/rooms/{code}/players/{name}/party-override", Name: "party-override", Summary: "Set a player's party mode alternative",
+			Request: (*Alternative)(nil), Status: http.StatusNoContent, Errors: roomErrors, Handler: V1SetOverride},
+		{Method: http.MethodDelete, Pattern: "/rooms/{code}/players/{name}/party-override", Name: "party-override", Summary: "Clear a player's party mode alternative",
+			Status: http.StatusNoContent, Errors: roomErrors, Handler: V1ClearOverride},
+		{Method: http.MethodPost, Pattern: "/rooms/{code}/moves", Name: "input", Summary: "Play a hole",
+			Request: (*MoveRequest)(nil), Status: http.StatusNoContent, Errors: moveErrors, Handler: V1Move},
+		{Method: http.MethodPost, Pattern: "/rooms/{code}/reset", Name: "input", Summary: "Start a new game once the current one has finished",
+			Status: http.StatusNoContent, Errors: playerErrors, Handler: V1Reset},
+		{Method: http.MethodPost, Pattern: "/rooms/{code}/pings", Name: "ping", Summary: "Nudge the player whose turn it is",
+			Request: (*PingRequest)(nil), Status: http.StatusNoContent, Errors: roomErrors, Handler: V1Ping},
+		{Method: http.MethodGet, Pattern: "/rooms/{code}/chat", Name: "chat", Summary: "Get recent chat messages and the available reactions",
+			Response: (*ChatResponse)(nil), Status: http.StatusOK, Errors: roomErrors, Handler: V1Messages},
+		{Method: http.MethodPost, Pattern: "/rooms/{code}/chat", Name: "chat", Summary: "Send a chat message or reaction",
+			Request: (*ChatRequest)(nil), Response: (*ChatMessage)(nil), Status: http.StatusCreated, Errors: append(roomErrors, http.StatusTooManyRequests), Handler: V1Chat},
+		{Method: http.MethodGet, Pattern: "/rooms/{code}/rules", Name: "rules", Summary: "List a room's rules",
+			Response: (*[]Rule)(nil), Status: http.StatusOK, Errors: roomErrors, Handler: V1Rules},
+		{Method: http.MethodPost, Pattern: "/rooms/{code}/rules", Name: "rule", Summary: "Add a rule",
+			Request: (*Rule)(nil), Response: (*RuleResponse)(nil), Status: http.StatusCreated, Errors: roomErrors, Handler: V1AddRule},
+		{Method: http.MethodDelete, Pattern: "/rooms/{code}/rules/{id}", Name: "rule", Summary: "Delete a rule, other than the first",
+			Status: http.StatusNoContent, Errors: append(roomErrors, http.StatusForbidden), Handler: V1DeleteRule},
+		{Method: http.MethodPut, Pattern: "/rooms/{code}/decks/{name}", Name: "deck", Summary: "Add or replace a deck of cards",
+			Request: (*DeckRequest)(nil), Status: http.StatusNoContent, Errors: roomErrors, Handler: V1PutDeck},
+		{Method: http.MethodDelete, Pattern: "/rooms/{code}/decks/{name}", Name: "deck", Summary: "Delete a deck",
+			Status: http.StatusNoContent, Errors: roomErrors, Handler: V1DeleteDeck},
+		{Method: http.MethodPut, Pattern: "/rooms/{code}/stones", Name: "stones", Summary: "Set the room's stone types",
+			Request: (*StonesRequest)(nil), Status: http.StatusNoContent, Errors: roomErrors, Handler: V1SetStones},
+		{Method: http.MethodGet, Pattern: "/rooms/{code}/history", Name: "history", Summary: "Page through a room's history",
+			Query: []QueryParam{{"offset", "integer"}, {"limit", "integer"}}, Response: (*HistoryResponse)(nil), Status: http.StatusOK, Errors: roomErrors, Handler: V1History},
+		{Method: http.MethodPut, Pattern: "/rooms/{code}/safety", Name: "safety", Summary: "Set the room's drink limits",
+			Request: (*SafetySettings)(nil), Status: http.StatusNoContent, Errors: roomErrors, Handler: V1SetSafety},
+		{Method: http.MethodPut, Pattern: "/rooms/{code}/party", Name: "party", Summary: "Turn party mode on or off and set its alternative",
+			Request: (*PartyRequest)(nil), Status: http.StatusNoContent, Errors: roomErrors, Handler: V1SetParty},
+		{Method: http.MethodPost, Pattern: "/rooms/{code}/series", Name: "series", Summary: "Start a best-of series",
+			Request: (*SeriesRequest)(nil), Status: http.StatusNoContent, Errors: playerErrors, Handler: V1StartSeries},
+		{Method: http.MethodDelete, Pattern: "/rooms/{code}/series", Name: "series", Summary: "Cancel the series in progress",
+			Status: http.StatusNoContent, Errors: roomErrors, Handler: V1CancelSeries},
+		{Method: http.MethodGet, Pattern: "/rooms/{code}/invite", Name: "invite", Summary: "Get the join link and QR codes of a room",
+			Response: (*InviteResponse)(nil), Status: http.StatusOK, Errors: roomErrors, Handler: V1Invite},
+		{Method: http.MethodGet, Pattern: "/rooms/{code}/qr", Name: "qr", Summary: "Get a QR code of a room's join link as png or svg",
+			Query: []QueryParam{{"format", "string"}}, ContentTypes: []string{"image/png", "image/svg+xml"}, Status: http.StatusOK, Errors: roomErrors, Handler: V1QR},
+		{Method: http.MethodPost, Pattern: "/simulations", Name: "simulate", Summary: "Simulate games between bots to see how often rules fire",
+			Request: (*SimulateRequest)(nil), Response: (*SimulationReport)(nil), Status: http.StatusOK, Errors: append(roomErrors, http.StatusServiceUnavailable), Handler: V1Simulate},
+		{Method: http.MethodGet, Pattern: "/leaderboard", Name: "leaderboard", Summary: "List profiles by rating",
+			Query: []QueryParam{{"limit", "integer"}}, Response: (*[]Profile)(nil), Status: http.StatusOK, Errors: requestErrors, Handler: V1Leaderboard},
+		{Method: http.MethodPost, Pattern: "/profiles", Name: "profile/create", Summary: "Create a profile, returning the token to join rooms with",
+			Request: (*ProfileRequest)(nil), Response: (*ProfileResponse)(nil), Status: http.StatusCreated, Errors: []int{http.StatusBadRequest, http.StatusConflict}, Handler: V1CreateProfile},
+		{Method: http.MethodGet, Pattern: "/profiles/{name}", Name: "profile", Summary: "Get a player's profile",
+			Response: (*Profile)(nil), Status: http.StatusOK, Errors: []int{http.StatusNotFound}, Handler: V1Profile},
+		{Method: http.MethodPost, Pattern: "/tournaments", Name: "tournament/create", Summary: "Create a tournament",
+			Request: (*TournamentRequest)(nil), Response: (*TournamentResponse)(nil), Status: http.StatusCreated, Errors: createErrors, Handler: V1CreateTournament},
+		{Method: http.MethodGet, Pattern: "/tournaments/{code}", Name: "tournament/state", Summary: "Get a tournament's state",
+			Response: (*Tournament)(nil), Status: http.StatusOK, Errors: []int{http.StatusNotFound}, Handler: V1GetTournament},
+		{Method: http.MethodGet, Pattern: "/tournaments/{code}/stream", Name: "tournament/stream", Summary: "Open a websocket of a tournament's updates",
+			Stream: true, Status: http.StatusSwitchingProtocols, Errors: streamErrors, Handler: V1TournamentStream},
+		{Method: http.MethodPost, Pattern: "/queue", Name: "queue", Summary: "Join the matchmaking queue",
+			Request: (*QueueRequest)(nil), Response: (*Ticket)(nil), Status: http.StatusCreated, Errors: createErrors, Handler: V1Enqueue},
+		{Method: http.MethodGet, Pattern: "/queue/{ticket}", Name: "queue", Summary: "Poll a queue ticket, which has a room code once matched",
+			Response: (*Ticket)(nil), Status: http.StatusOK, Errors: []int{http.StatusNotFound}, Handler: V1PollTicket},
+		{Method: http.MethodDelete, Pattern: "/queue/{ticket}", Name: "queue", Summary: "Leave the matchmaking queue",
+			Status: http.StatusNoContent, Errors: []int{http.StatusNotFound}, Handler: V1CancelTicket},
+		{Method: http.MethodGet, Pattern: "/openapi.json", Name: "openapi", Summary: "This document",
+			ContentTypes: []string{"application/json"}, Status: http.StatusOK, Handler: V1OpenAPI},
+		{Method: http.MethodGet, Pattern: "/stream-schema.json", Name: "stream-schema", Summary: "JSON schema of the websocket messages",
+			ContentTypes: []string{"application/json"}, Status: http.StatusOK, Handler: V1StreamSchema},
+	}
+}
+
+// NewV1Router routes the versioned API. Rooms, players and the other
+// resources are addressed by path, and the method says what to do with them.
+func NewV1Router(api *API, limits *RateLimits) *Router {
+	rt := NewRouter(API_V1_PREFIX)
+	for _, route := range V1Routes() {
+		handler := route.Handler(api)
+		if route.Method != http.MethodGet {
+			handler = limits.Wrap(route.Name, handler)
+		}
+		rt.Handle(route.Method, route.Pattern, Instrument("v1/"+route.Name, handler))
+	}
+	return rt
+}
+
+// queryInt reads an optional integer query parameter.
+func queryInt(w http.ResponseWriter, r *http.Request, name string, value *int) bool {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		WriteError(w, name+" must be a number", http.StatusBadRequest)
+		return false
+	}
+	*value = n
+	return true
+}
+
+// roomParams reads the room code and player name from the path, and adds
+// them to the request's log fields.
+func roomParams(r *http.Request) (string, string) {
+	code, name := PathParam(r, "code"), PathParam(r, "name")
+	Annotate(r, code, name)
+	return code, name
+}
+
+func V1ListRooms(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		size := 0
+		if !queryInt(w, r, "size", &size) {
+			return
+		}
+		WriteResult(w, http.StatusOK, api.ListRooms(size), nil)
+	}
+}
+
+func V1CreateRoom(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateRoomRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		res, err := api.CreateRoom(req)
+		if err == nil {
+			w.Header().Set("Location", API_V1_PREFIX+"/rooms/"+res.Code)
+		}
+		WriteResult(w, http.StatusCreated, res, err)
+	}
+}
+
+func V1GetRoom(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		state, err := api.GetRoom(code)
+		WriteResult(w, http.StatusOK, state, err)
+	}
+}
+
+// V1JoinRoom answers 201 for a new player and 200 for one rejoining.
+func V1JoinRoom(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		var req JoinRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		Annotate(r, code, req.Name)
+		state, created, err := api.JoinRoom(code, req)
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		WriteResult(w, status, state, err)
+	}
+}
+
+func V1Stream(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, name := roomParams(r)
+		api.Stream(w, r, code, name)
+	}
+}
+
+func V1SetOptOut(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, name := roomParams(r)
+		var req OptOutRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		WriteResult(w, http.StatusNoContent, nil, api.SetOptOut(code, name, req.OptOut))
+	}
+}
+
+func V1SetOverride(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, name := roomParams(r)
+		var req Alternative
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		WriteResult(w, http.StatusNoContent, nil, api.SetOverride(code, name, &req))
+	}
+}
+
+func V1ClearOverride(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, name := roomParams(r)
+		WriteResult(w, http.StatusNoContent, nil, api.SetOverride(code, name, nil))
+	}
+}
+
+func V1Move(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		var req MoveRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		Annotate(r, code, req.Player)
+		WriteResult(w, http.StatusNoContent, nil, api.Move(code, req, RequestLogger(r)))
+	}
+}
+
+func V1Reset(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		WriteResult(w, http.StatusNoContent, nil, api.Reset(code))
+	}
+}
+
+func V1Ping(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		var req PingRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		Annotate(r, code, req.Name)
+		WriteResult(w, http.StatusNoContent, nil, api.Ping(code, req))
+	}
+}
+
+func V1Messages(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		res, err := api.Messages(code)
+		WriteResult(w, http.StatusOK, res, err)
+	}
+}
+
+func V1Chat(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		var req ChatRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		Annotate(r, code, req.Name)
+		chat, err := api.Chat(code, req)
+		WriteResult(w, http.StatusCreated, chat, err)
+	}
+}
+
+func V1Rules(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		rules, err := api.Rules(code)
+		WriteResult(w, http.StatusOK, rules, err)
+	}
+}
+
+func V1AddRule(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		var req Rule
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		res, err := api.AddRule(code, req)
+		WriteResult(w, http.StatusCreated, res, err)
+	}
+}
+
+func V1DeleteRule(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		id, err := strconv.Atoi(PathParam(r, "id"))
+		if err != nil {
+			WriteError(w, "rule id must be a number", http.StatusBadRequest)
+			return
+		}
+		WriteResult(w, http.StatusNoContent, nil, api.DeleteRule(code, id))
+	}
+}
+
+func V1PutDeck(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		var req DeckRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		WriteResult(w, http.StatusNoContent, nil, api.PutDeck(code, PathParam(r, "name"), req.Cards))
+	}
+}
+
+func V1DeleteDeck(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		WriteResult(w, http.StatusNoContent, nil, api.DeleteDeck(code, PathParam(r, "name")))
+	}
+}
+
+func V1SetStones(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		var req StonesRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		WriteResult(w, http.StatusNoContent, nil, api.SetStones(code, req.Stones))
+	}
+}
+
+func V1History(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		offset, limit := 0, 0
+		if !queryInt(w, r, "offset", &offset) || !queryInt(w, r, "limit", &limit) {
+			return
+		}
+		res, err := api.History(code, offset, limit)
+		WriteResult(w, http.StatusOK, res, err)
+	}
+}
+
+func V1SetSafety(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		var req SafetySettings
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		WriteResult(w, http.StatusNoContent, nil, api.SetSafety(code, req))
+	}
+}
+
+func V1SetParty(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		var req PartyRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		WriteResult(w, http.StatusNoContent, nil, api.SetParty(code, req))
+	}
+}
+
+func V1StartSeries(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		var req SeriesRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		WriteResult(w, http.StatusNoContent, nil, api.StartSeries(code, req.BestOf))
+	}
+}
+
+func V1CancelSeries(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		WriteResult(w, http.StatusNoContent, nil, api.CancelSeries(code))
+	}
+}
+
+func V1Invite(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		res, err := api.Invite(r, code, API_V1_PREFIX+"/rooms/"+NormalizeCode(code)+"/qr?")
+		WriteResult(w, http.StatusOK, res, err)
+	}
+}
+
+func V1QR(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, _ := roomParams(r)
+		img, contentType, err := api.QR(r, code, r.URL.Query().Get("format"))
+		WriteImage(w, img, contentType, err)
+	}
+}
+
+func V1Simulate(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req SimulateRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		Annotate(r, req.Code, "")
+		report, err := api.Simulate(r.Context(), req)
+		WriteResult(w, http.StatusOK, report, err)
+	}
+}
+
+func V1Leaderboard(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 0
+		if !queryInt(w, r, "limit", &limit) {
+			return
+		}
+		WriteResult(w, http.StatusOK, api.Leaderboard(limit), nil)
+	}
+}
+
+func V1CreateProfile(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ProfileRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		Annotate(r, "", req.Name)
+		res, err := api.CreateProfile(req)
+		WriteResult(w, http.StatusCreated, res, err)
+	}
+}
+
+func V1Profile(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		profile, err := api.Profile(PathParam(r, "name"))
+		WriteResult(w, http.StatusOK, profile, err)
+	}
+}
+
+func V1CreateTournament(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TournamentRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		res, err := api.CreateTournament(req)
+		if err == nil {
+			w.Header().Set("Location", API_V1_PREFIX+"/tournaments/"+res.Code)
+		}
+		WriteResult(w, http.StatusCreated, res, err)
+	}
+}
+
+func V1GetTournament(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err := api.GetTournament(PathParam(r, "code"))
+		WriteResult(w, http.StatusOK, state, err)
+	}
+}
+
+func V1TournamentStream(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		api.TournamentStream(w, r, PathParam(r, "code"))
+	}
+}
+
+func V1Enqueue(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req QueueRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		ticket, err := api.Enqueue(req)
+		if err == nil {
+			w.Header().Set("Location", API_V1_PREFIX+"/queue/"+ticket.Id)
+		}
+		WriteResult(w, http.StatusCreated, ticket, err)
+	}
+}
+
+func V1PollTicket(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ticket, err := api.PollTicket(PathParam(r, "ticket"))
+		WriteResult(w, http.StatusOK, ticket, err)
+	}
+}
+
+func V1CancelTicket(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		WriteResult(w, http.StatusNoContent, nil, api.CancelTicket(PathParam(r, "ticket")))
+	}
+}
+
+func V1OpenAPI(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		WriteResult(w, http.StatusOK, OpenAPI(), nil)
+	}
+}
+
+func V1StreamSchema(api *API) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		WriteResult(w, http.StatusOK, StreamSchema(), nil)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MAX_NAME_LENGTH  = 24  // Default limit for player and participant names
+	MAX_LABEL_LENGTH = 32  // Deck names, stone labels and colours
+	MAX_TEXT_LENGTH  = 200 // Default limit for rule prompts, cards and alternatives
+)
+
+// DefaultFilterWords are filtered out of user-supplied text unless the server
+// is given its own list.
+var DefaultFilterWords = []string{
+	"arse", "arsehole", "asshole", "bastard", "bitch", "bollocks", "cock",
+	"cunt", "dick", "fuck", "fucking", "motherfucker", "piss", "prick",
+	"shit", "slut", "twat", "wanker", "whore",
+}
+
+var wordFilter = compileWordFilter(DefaultFilterWords)
+
+func compileWordFilter(words []string) *regexp.Regexp {
+	quoted := []string{}
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// SetWordFilter replaces the filtered words. An empty list turns filtering off.
+// Must be called before the server starts handling requests.
+func SetWordFilter(words []string) {
+	wordFilter = compileWordFilter(words)
+}
+
+// LoadWordFilter reads filtered words from a file, one per line. Blank lines
+// and lines starting with # are skipped.
+func LoadWordFilter(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+// MaskWords replaces filtered words with asterisks, keeping their first letter.
+func MaskWords(text string) string {
+	if wordFilter == nil {
+		return text
+	}
+	return wordFilter.ReplaceAllStringFunc(text, func(word string) string {
+		_, size := utf8.DecodeRuneInString(word)
+		return word[:size] + strings.Repeat("*", utf8.RuneCountInString(word)-1)
+	})
+}
+
+// isHiddenRune reports control characters and the bidi overrides that can be
+// used to make text display differently to how it reads.
+func isHiddenRune(r rune) bool {
+	return unicode.IsControl(r) || (r >= '‪' && r <= '‮') || (r >= '⁦' && r <= '⁩')
+}
+
+func checkText(field string, text string, max int) (string, error) {
+	if !utf8.ValidString(text) {
+		return "", errors.New(field + " is not valid text")
+	}
+	text = strings.TrimSpace(text)
+	if strings.IndexFunc(text, isHiddenRune) >= 0 {
+		return "", errors.New(field + " can not contain control characters")
+	}
+	if utf8.RuneCountInString(text) > max {
+		return "", fmt.Errorf("%s can be at most %d characters", field, max)
+	}
+	return text, nil
+}
+
+// CleanName checks a player name. Names aren't filtered, masking would change
+// the name players are identified by and plenty of real names contain a word
+// from the list.
+func CleanName(name string) (string, error) {
+	name, err := checkText("name", name, config.Limits.MaxNameLength)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", errors.New("name missing")
+	}
+	return name, nil
+}
+
+// CleanText checks free text and masks any filtered words in it. Empty text
+// is allowed, callers that need text check for it themselves.
+func CleanText(field string, text string, max int) (string, error) {
+	text, err := checkText(field, text, max)
+	if err != nil {
+		return "", err
+	}
+	return MaskWords(text), nil
+}
+
+// EscapeFormatVerbs makes text safe to use as a format with a single int
+// argument: the first %d is kept for the value and every other % is escaped.
+func EscapeFormatVerbs(text string) string {
+	var sb strings.Builder
+	embedded := false
+	for i := 0; i < len(text); i++ {
+		if text[i] != '%' {
+			sb.WriteByte(text[i])
+			continue
+		}
+		if i+1 < len(text) && text[i+1] == '%' {
+			sb.WriteString("%%")
+			i++
+		} else if i+1 < len(text) && text[i+1] == 'd' && !embedded {
+			sb.WriteString("%d")
+			embedded = true
+			i++
+		} else {
+			sb.WriteString("%%")
+		}
+	}
+	return sb.String()
+}
+
+// Clean returns the rule with its user-supplied text checked and filtered. The
+// text is kept as written, Combine escapes it when embedding a value.
+func (r Rule) Clean() (Rule, error) {
+	var err error
+	if r.Text, err = CleanText("rule text", r.Text, config.Limits.MaxTextLength); err != nil {
+		return r, err
+	}
+	if r.Deck, err = checkText("deck name", r.Deck, MAX_LABEL_LENGTH); err != nil {
+		return r, err
+	}
+	if r.DeckLevel < DECK_ROLL_LEVEL {
+		return r, fmt.Errorf("deck level %d is not a level", r.DeckLevel)
+	}
+	if r.StoneLabel, err = checkText("stone label", r.StoneLabel, MAX_LABEL_LENGTH); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// Clean returns the alternative with its text checked and filtered, and
+// validates it.
+func (a Alternative) Clean() (Alternative, error) {
+	var err error
+	if a.Text, err = CleanText("alternative text", a.Text, config.Limits.MaxTextLength); err != nil {
+		return a, err
+	}
+	if a.Deck, err = checkText("deck name", a.Deck, MAX_LABEL_LENGTH); err != nil {
+		return a, err
+	}
+	return a, a.Validate()
+}
+
+func (s SafetySettings) Clean() (SafetySettings, error) {
+	var err error
+	if s.Alternative, err = CleanText("alternative", s.Alternative, config.Limits.MaxTextLength); err != nil {
+		return s, err
+	}
+	return s, s.Validate()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCleanName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"  Ada ", "Ada", true},
+		{"Dick", "Dick", true},
+		{"Cockburn", "Cockburn", true},
+		{"", "", false},
+		{"   ", "", false},
+		{"a‮name", "", false},
+		{"tab\tname", "", false},
+		{strings.Repeat("x", MAX_NAME_LENGTH+1), "", false},
+	}
+	for _, test := range tests {
+		got, err := CleanName(test.name)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("CleanName(%q) = %q, %v, want %q ok %v", test.name, got, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestCleanRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		text    string // text kept by Clean
+		combine string // what Combine makes of it with a value of 3
+		ok      bool
+	}{
+		{"plain", Rule{Text: "drink"}, "drink", "a: drink", true},
+		{"masked", Rule{Text: "shit happens"}, "s*** happens", "a: s*** happens", true},
+		{"embedded value", Rule{Text: "drink %d sips", EmbedValue: true}, "drink %d sips", "a: drink 3 sips", true},
+		{"percent kept", Rule{Text: "100% drink %d", EmbedValue: true}, "100% drink %d", "a: 100% drink 3", true},
+		{"verbs not embedded", Rule{Text: "%s %d"}, "%s %d", "a: %s %d", true},
+		{"too long", Rule{Text: strings.Repeat("x", MAX_TEXT_LENGTH+1)}, "", "", false},
+		{"bad deck level", Rule{Text: "drink", DeckLevel: DECK_ROLL_LEVEL - 1}, "", "", false},
+	}
+	room, err := NewRoom("TEST", 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.Players = append(room.Players, &Player{Name: "a", Conns: map[Conn]bool{}})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := test.rule.Clean()
+			if (err == nil) != test.ok {
+				t.Fatalf("got error %v, want ok %v", err, test.ok)
+			}
+			if err != nil {
+				return
+			}
+			if rule.Text != test.text {
+				t.Errorf("text %q, want %q", rule.Text, test.text)
+			}
+			if got := room.Combine([]int{0}, rule, 3); len(got) != 1 || got[0] != test.combine {
+				t.Errorf("combined %q, want %q", got, test.combine)
+			}
+		})
+	}
+}
+
+func TestSimulateCleansRules(t *testing.T) {
+	api := &API{}
+	_, err := api.Simulate(context.Background(), SimulateRequest{Size: 2, Games: 1, Rules: []Rule{{Text: "fine"}, {Text: "bad\x00"}}})
+	if err == nil || ErrorStatus(err) != 400 {
+		t.Errorf("got error %v, want a bad request", err)
+	}
+}